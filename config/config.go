@@ -3,7 +3,56 @@
 // Package config implements DGit configuration data types
 package config
 
-import "io/fs"
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Default values used in place of zero-valued [Config] fields.
+const (
+	// DefaultIndexPageSize is the default number of repositories
+	// presented per page of the index.
+	DefaultIndexPageSize = 50
+	// DefaultHighlightMaxSize is the default size, in bytes, of
+	// the largest blob highlighted.
+	DefaultHighlightMaxSize = 256 << 10
+	// DefaultLogPageSize is the default number of log entries
+	// presented per page.
+	DefaultLogPageSize = 20
+	// DefaultDiffContext is the default number of context lines
+	// presented on either side of a change in diffs.
+	DefaultDiffContext = 3
+	// DefaultRenameThreshold is the default similarity, as a
+	// percentage, above which a file is detected as renamed or
+	// copied.
+	DefaultRenameThreshold = 50
+	// DefaultMaxDiffFiles is the default number of files presented
+	// per diff.
+	DefaultMaxDiffFiles = 1000
+	// DefaultMaxDiffLines is the default number of lines presented
+	// per file of a diff.
+	DefaultMaxDiffLines = 10000
+	// DefaultMaxBlobSize is the default size, in bytes, of the
+	// largest blob displayed.
+	DefaultMaxBlobSize = 10 << 20
+	// DefaultMaxPatchCommits is the default number of commits in
+	// a patch series.
+	DefaultMaxPatchCommits = 100
+	// DefaultRefsPageSize is the default number of branches, and
+	// of tags, presented per page of refs.
+	DefaultRefsPageSize = 50
+	// DefaultNotesRef is the notes ref from which notes are read
+	// when NotesRefs is nil.
+	DefaultNotesRef = "refs/notes/commits"
+)
 
 // BUG(djmoch): DGit does not support the "repository owner" field in
 // project list file entries, and attempting to specify one will cause
@@ -35,6 +84,95 @@ type Config struct {
 	// URL if it exists in the path.
 	RemoveSuffix bool
 
+	// IndexPageSize is the number of repositories presented per
+	// page of the index. If zero, DefaultIndexPageSize is used.
+	IndexPageSize int
+
+	// LogPageSize is the number of log entries presented per
+	// page. If zero, DefaultLogPageSize is used.
+	LogPageSize int
+
+	// RefsPageSize is the number of branches, and of tags,
+	// presented per page of refs. If zero, DefaultRefsPageSize is
+	// used.
+	RefsPageSize int
+
+	// DiffContext is the number of context lines presented on
+	// either side of a change in diffs, unless overridden by the
+	// context query parameter. If zero, DefaultDiffContext is
+	// used. If negative, no context lines are presented: unlike the
+	// limits below, a negative value means none, not unlimited.
+	DiffContext int
+
+	// RenameThreshold is the similarity, as a percentage, at or
+	// above which a file in a diff is detected as renamed or copied
+	// from another. If zero, DefaultRenameThreshold is used. If
+	// negative, rename and copy detection is disabled.
+	RenameThreshold int
+
+	// MaxDiffFiles is the number of files presented per diff.
+	// Further files are omitted. If zero, DefaultMaxDiffFiles is
+	// used. If negative, there is no limit, rather than no files
+	// being presented.
+	MaxDiffFiles int
+
+	// MaxDiffLines is the number of lines presented per file of a
+	// diff. Further lines are omitted. If zero,
+	// DefaultMaxDiffLines is used. If negative, there is no limit,
+	// rather than no lines being presented.
+	MaxDiffLines int
+
+	// MaxBlobSize is the size, in bytes, of the largest blob
+	// displayed in a blob page or diff. Larger blobs are omitted,
+	// but remain available raw. If zero, DefaultMaxBlobSize is
+	// used. If negative, there is no limit, and blobs of any size
+	// are read into memory to be displayed.
+	MaxBlobSize int64
+
+	// MaxPatchCommits is the number of commits in the largest
+	// patch series served for a commit range. Larger series are
+	// refused, as are patches and plain diffs exceeding
+	// MaxDiffFiles, MaxDiffLines or MaxBlobSize, which cannot be
+	// truncated without breaking them. If zero,
+	// DefaultMaxPatchCommits is used. If negative, there is no
+	// limit, rather than no series being served.
+	MaxPatchCommits int
+
+	// Renderers maps file extensions, including the leading dot
+	// (e.g. ".rst"), to the [Renderer] used to display files with
+	// that extension. Extensions are matched without regard to
+	// case. Renderers are used for both tree README's and blobs.
+	// Markdown files (.md, .mkd and .markdown) are rendered by
+	// default. Mapping one of their extensions to a different
+	// Renderer replaces the default, and mapping it to nil
+	// disables rendering.
+	Renderers map[string]Renderer
+
+	// Avatars provides the avatar images shown for commit authors
+	// and committers. See [HashAvatars] and [DirAvatars]. If nil,
+	// no avatars are shown.
+	Avatars AvatarProvider
+
+	// LinkRules link text in commit messages, such as issue
+	// references, to URLs. Where the matches of rules overlap, the
+	// earliest is linked, and of those starting together, that of
+	// the first rule. Abbreviated and full hashes of commits in the
+	// repository are linked to those commits in any case.
+	LinkRules []LinkRule
+
+	// NotesRefs are the refs, such as refs/notes/review, from which
+	// git notes on commits are read, in order. Each may also be a
+	// pattern, as matched by [path.Match], such as refs/notes/*.
+	// Refs that do not exist are ignored. If nil, DefaultNotesRef
+	// is used. If empty but not nil, no notes are read.
+	NotesRefs []string
+
+	// HighlightMaxSize is the size, in bytes, of the largest blob
+	// for which syntax highlighting is performed. Larger blobs are
+	// displayed as plain text. If zero, DefaultHighlightMaxSize
+	// is used. If negative, syntax highlighting is disabled.
+	HighlightMaxSize int64
+
 	// Templates is an [fs.FS] that contains the HTML template
 	// files (see [html/template]). The templates must live inside
 	// the FS in a "templates" directory. File names end in .tmpl
@@ -45,6 +183,7 @@ type Config struct {
 	// The full list of required template files is:
 	//   - blob.tmpl
 	//   - commit.tmpl
+	//   - compare.tmpl
 	//   - diff.tmpl
 	//   - error.tmpl
 	//   - index.tmpl
@@ -53,3 +192,102 @@ type Config struct {
 	//   - tree.tmpl
 	Templates fs.FS
 }
+
+// A Renderer renders the markup contained in src as HTML. The file
+// being rendered is described by f. Renderers are responsible for
+// sanitizing their output.
+type Renderer func(f RenderFile, src []byte) (template.HTML, error)
+
+// RenderFile describes a file rendered by a [Renderer].
+type RenderFile struct {
+	// Repo is the repository slug.
+	Repo string
+	// Revision is the revision at which the file is rendered.
+	Revision string
+	// Path is the path of the file within the repository.
+	Path string
+}
+
+// A LinkRule links text matching Pattern to URL. For example:
+//
+//	config.LinkRule{
+//		Pattern: regexp.MustCompile(`#(\d+)`),
+//		URL:     "https://bugs.example.com/show_bug.cgi?id=$1",
+//	}
+type LinkRule struct {
+	// Pattern matches the text to be linked.
+	Pattern *regexp.Regexp
+	// URL is the link target. Submatches of Pattern are expanded
+	// in it, as by [regexp.Regexp.Expand]. Only relative, http,
+	// https and mailto URLs are linked.
+	URL string
+}
+
+// An AvatarProvider returns the URL of the avatar image for the given
+// email address, or an empty string if there is none.
+type AvatarProvider func(email string) string
+
+// HashAvatars returns an [AvatarProvider] for a hashed-email avatar
+// service, such as Libravatar or Gravatar. Each occurrence of {md5} or
+// {sha256} in urlTemplate is replaced by the hex-encoded hash of the
+// trimmed, lower-case email address. For example:
+//
+//	config.HashAvatars("https://seccdn.libravatar.org/avatar/{sha256}?s=40&d=identicon")
+func HashAvatars(urlTemplate string) AvatarProvider {
+	return func(email string) string {
+		email = normalizeEmail(email)
+		if email == "" {
+			return ""
+		}
+		u := urlTemplate
+		if strings.Contains(u, "{md5}") {
+			sum := md5.Sum([]byte(email))
+			u = strings.ReplaceAll(u, "{md5}", hex.EncodeToString(sum[:]))
+		}
+		if strings.Contains(u, "{sha256}") {
+			sum := sha256.Sum256([]byte(email))
+			u = strings.ReplaceAll(u, "{sha256}", hex.EncodeToString(sum[:]))
+		}
+		return u
+	}
+}
+
+// avatarExts are the extensions of the images found by [DirAvatars],
+// in order of preference.
+var avatarExts = []string{".png", ".jpg", ".jpeg", ".gif", ".webp", ".svg"}
+
+// DirAvatars returns an [AvatarProvider] for a directory of images,
+// fsys, named by lower-case email address with an image extension
+// (e.g. alice@example.com.png). The URL of an image is its name,
+// escaped, under baseURL. DGit does not serve the images itself: they
+// must be served at baseURL, for example by an [http.FileServer].
+//
+// [http.FileServer]: https://pkg.go.dev/net/http#FileServer
+func DirAvatars(fsys fs.FS, baseURL string) AvatarProvider {
+	return func(email string) string {
+		email = normalizeEmail(email)
+		if email == "" || !fs.ValidPath(email) || strings.Contains(email, "/") {
+			return ""
+		}
+		for _, ext := range avatarExts {
+			_, err := fs.Stat(fsys, email+ext)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return ""
+			}
+			u, err := url.Parse(baseURL)
+			if err != nil {
+				return ""
+			}
+			u.Path = path.Join(u.Path, email+ext)
+			return u.String()
+		}
+		return ""
+	}
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,43 @@
+// See LICENSE file for copyright and license details
+
+package config
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestHashAvatars(t *testing.T) {
+	avatars := HashAvatars("https://example.com/avatar/{md5}?s=40")
+	exp := "https://example.com/avatar/c160f8cc69a4f0bf2b0362752353d060?s=40"
+	if act := avatars(" Alice@Example.com "); act != exp {
+		t.Fatalf("exp=%s, act=%s", exp, act)
+	}
+	avatars = HashAvatars("https://example.com/avatar/{sha256}")
+	exp = "https://example.com/avatar/ff8d9819fc0e12bf0d24892e45987e249a28dce836a85cad60e28eaaa8c6d976"
+	if act := avatars("alice@example.com"); act != exp {
+		t.Fatalf("exp=%s, act=%s", exp, act)
+	}
+	if act := avatars(""); act != "" {
+		t.Fatal("expected no avatar for empty email, but got", act)
+	}
+}
+
+func TestDirAvatars(t *testing.T) {
+	fsys := fstest.MapFS{
+		"alice@example.com.jpg": {},
+		"alice@example.com.png": {},
+		"bob@example.com.svg":   {},
+	}
+	avatars := DirAvatars(fsys, "/avatars/")
+	for email, exp := range map[string]string{
+		"Alice@example.com": "/avatars/alice@example.com.png",
+		"bob@example.com":   "/avatars/bob@example.com.svg",
+		"carol@example.com": "",
+		"../etc/passwd":     "",
+	} {
+		if act := avatars(email); act != exp {
+			t.Errorf("%s: exp=%q, act=%q", email, exp, act)
+		}
+	}
+}
@@ -10,27 +10,121 @@ import (
 	"fmt"
 	"html/template"
 	"log"
+	"net/url"
 	"path"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
-)
-
-// TODO(djmoch): Migrate the below variables into config.Config
 
-var (
-	// LogPageSize is the number of log entries presented per
-	// page.
-	LogPageSize = 20
-	// DiffContext is t he number of context lines presented on
-	// either side of a diff
-	DiffContext = 3
+	"github.com/sergi/go-diff/diffmatchpatch"
 )
 
 // IndexData is provided to the index template when executed and
 // becomes dot within the template.
 type IndexData struct {
-	// Repos is a slice of repositories
+	// Repos is a slice of repositories on the current page
+	Repos []*Repo
+	// Total is the number of repositories matching the active
+	// filters, across all pages
+	Total int
+	// Query is the active name or description filter
+	Query string
+	// Owner is the active owner filter
+	Owner string
+	// Category is the active category filter
+	Category string
+	// Sort is the active sort order: one of "age", "name" or
+	// "owner"
+	Sort string
+	// Page is the current page number, starting at 1
+	Page int
+	// Links to the previous and next pages, empty when there is
+	// no such page
+	PrevPage, NextPage string
+}
+
+// HasPrev returns true if d.PrevPage is not empty.
+func (d IndexData) HasPrev() bool {
+	return d.PrevPage != ""
+}
+
+// HasNext returns true if d.NextPage is not empty.
+func (d IndexData) HasNext() bool {
+	return d.NextPage != ""
+}
+
+// IsFiltered returns true if any of the Query, Owner or Category
+// filters are active.
+func (d IndexData) IsFiltered() bool {
+	return d.Query != "" || d.Owner != "" || d.Category != ""
+}
+
+// SortLink returns a link to the first page of the index sorted by
+// sort, retaining the active filters.
+func (d IndexData) SortLink(sort string) string {
+	return d.Link(1, sort)
+}
+
+// Link returns a link to the given page of the index sorted by sort,
+// retaining the active filters.
+func (d IndexData) Link(page int, sort string) string {
+	v := make(url.Values)
+	if d.Query != "" {
+		v.Set("q", d.Query)
+	}
+	if d.Owner != "" {
+		v.Set("owner", d.Owner)
+	}
+	if d.Category != "" {
+		v.Set("category", d.Category)
+	}
+	if sort != "" {
+		v.Set("sort", sort)
+	}
+	if page > 1 {
+		v.Set("page", strconv.Itoa(page))
+	}
+	return "?" + v.Encode()
+}
+
+// Categories groups the repositories in Repos by category.
+// Categories are sorted by name, with repositories lacking a category
+// grouped last under the empty name. Within each category,
+// repositories retain their order in Repos.
+func (d IndexData) Categories() []Category {
+	var (
+		categories = make([]Category, 0)
+		index      = make(map[string]int)
+	)
+	for _, r := range d.Repos {
+		i, ok := index[r.Category]
+		if !ok {
+			i = len(categories)
+			index[r.Category] = i
+			categories = append(categories, Category{Name: r.Category})
+		}
+		categories[i].Repos = append(categories[i].Repos, r)
+	}
+	sort.SliceStable(categories, func(i, j int) bool {
+		switch {
+		case categories[i].Name == "":
+			return false
+		case categories[j].Name == "":
+			return true
+		}
+		return categories[i].Name < categories[j].Name
+	})
+	return categories
+}
+
+// Category is a group of repositories sharing the same category.
+type Category struct {
+	// Name is the category name. It is empty for repositories
+	// without a category.
+	Name string
+	// Repos is a slice of repositories in the category
 	Repos []*Repo
 }
 
@@ -45,10 +139,46 @@ type Repo struct {
 	// Description is the repository description as read from the
 	// gitweb.description Git config key.
 	Description string
+	// Category is the repository category as read from the
+	// gitweb.category Git config key.
+	Category string
+	// Options contains the options in the dgit section of the
+	// repository's Git config, keyed by lower-case option name.
+	// Options within a subsection are keyed as
+	// "subsection.option".
+	Options map[string]string
 	// LastModified records the timestamp of the most recent
-	// commit as read from info/web/last-modified within the
-	// repository's Git directory.
+	// commit. See LastModifiedSource for its origin.
 	LastModified time.Time
+	// LastModifiedSource records where LastModified was read from.
+	LastModifiedSource LastModifiedSource
+}
+
+// LastModifiedSource describes where [Repo.LastModified] was read
+// from.
+type LastModifiedSource uint8
+
+// These are recognized [LastModifiedSource] values.
+const (
+	// The last-modified time is unknown
+	LastModifiedUnknown LastModifiedSource = iota
+	// Read from info/web/last-modified within the repository's Git
+	// directory
+	LastModifiedAgeFile
+	// Computed as the newest committer time across branch heads
+	LastModifiedRefs
+)
+
+// String returns a short description of l.
+func (l LastModifiedSource) String() string {
+	switch l {
+	case LastModifiedAgeFile:
+		return "agefile"
+	case LastModifiedRefs:
+		return "refs"
+	default:
+		return "unknown"
+	}
 }
 
 // RequestData is the base type for several of the other data types.
@@ -120,23 +250,43 @@ type TreeData struct {
 	Commit Commit
 	// The Tree itself.
 	Tree Tree
-	// Tree README contents.
+	// The file name of the tree README, if any.
+	ReadmeName string
+	// Tree README contents, when the README is not rendered.
 	Readme string
-	// Tree README markdown contents.
-	MarkdownReadme template.HTML
+	// Tree README contents rendered as HTML. See
+	// [config.Config.Renderers].
+	RenderedReadme template.HTML
+	// The table of contents of the rendered README.
+	TOC []Heading
 }
 
-// HasReadme returns true if the tree has a file named README. When
-// true, the README contents are available in TreeData.Readme.
+// HasReadme returns true if the tree has a README that is not
+// rendered. When true, the README contents are available in
+// TreeData.Readme.
 func (t TreeData) HasReadme() bool {
 	return t.Readme != ""
 }
 
-// HasMarkdownReadme returns true if the tree has a file named
-// README.md. When true, the README contents are available in
-// TreeData.MarkdownReadme.
+// HasRenderedReadme returns true if the tree has a README with a
+// renderer, such as README.md. When true, the rendered README is
+// available in TreeData.RenderedReadme.
+func (t TreeData) HasRenderedReadme() bool {
+	return t.RenderedReadme != ""
+}
+
+// MarkdownReadme returns t.RenderedReadme.
+//
+// Deprecated: Use the RenderedReadme field.
+func (t TreeData) MarkdownReadme() template.HTML {
+	return t.RenderedReadme
+}
+
+// HasMarkdownReadme returns true if the tree has a rendered README.
+//
+// Deprecated: Use [TreeData.HasRenderedReadme].
 func (t TreeData) HasMarkdownReadme() bool {
-	return t.MarkdownReadme != ""
+	return t.HasRenderedReadme()
 }
 
 // IsEmpty returns true if the Tree is empty.
@@ -150,15 +300,47 @@ type Commit struct {
 	Hash Hash
 	// Author is the original author of the commit.
 	Author string
+	// AuthorEmail is the email address of Author.
+	AuthorEmail string
+	// AuthorTime is the time the change was authored, in the
+	// author's time zone.
+	AuthorTime time.Time
+	// AuthorAvatar is the URL of Author's avatar image, empty if
+	// there is none.
+	AuthorAvatar string
 	// Committer is the one performing the commit, might be different from
 	// Author.
 	Committer string
+	// CommitterEmail is the email address of Committer.
+	CommitterEmail string
+	// CommitterAvatar is the URL of Committer's avatar image, empty
+	// if there is none.
+	CommitterAvatar string
 	// Message is the commit message, contains arbitrary text.
 	Message string
+	// Body is the full commit message, including the subject,
+	// without its trailers.
+	Body string
+	// Trailers are the trailers at the end of the commit message,
+	// such as Signed-off-by, in order.
+	Trailers []Trailer
+	// LinkedSubject is the first line of the commit message, as
+	// HTML with issue references, commit hashes and the like
+	// linked.
+	LinkedSubject template.HTML
+	// LinkedBody is Body, as HTML with issue references, commit
+	// hashes and the like linked.
+	LinkedBody template.HTML
+	// Notes are the git notes on the commit, in the order of the
+	// configured notes refs. They are only read for commit pages.
+	Notes []Note
 	// ParentHashes are the hash(es) of the parent commit(s)
 	ParentHashes []Hash
-	// Time is the commit timestamp
+	// Time is the commit timestamp, in the committer's time zone
 	Time time.Time
+	// Graph is the row of the commit graph drawn alongside the
+	// commit in a log. It is nil outside of a log.
+	Graph *GraphRow
 }
 
 // HasParents returns true when c has one or more parents. Otherwise
@@ -167,6 +349,164 @@ func (c Commit) HasParents() bool {
 	return len(c.ParentHashes) != 0
 }
 
+// Subject returns the first line of c's message.
+func (c Commit) Subject() string {
+	subject, _, _ := strings.Cut(c.Message, "\n")
+	return subject
+}
+
+// Trailer returns the values of c's trailers with the given key,
+// matched without regard to case, in order.
+func (c Commit) Trailer(key string) []string {
+	var values []string
+	for _, t := range c.Trailers {
+		if strings.EqualFold(t.Key, key) {
+			values = append(values, t.Value)
+		}
+	}
+	return values
+}
+
+// Trailer is a "Key: value" line, such as "Signed-off-by: Alice
+// <alice@example.com>", in the final paragraph of a commit message.
+type Trailer struct {
+	// The key, as written
+	Key string
+	// The value, with continuation lines joined by spaces
+	Value string
+	// The value, as HTML with issue references, commit hashes and
+	// the like linked
+	LinkedValue template.HTML
+}
+
+// Note is a git note attached to a commit.
+type Note struct {
+	// The notes ref holding the note, without its refs/notes/
+	// prefix (e.g. "commits")
+	Ref string
+	// The text of the note
+	Text string
+	// The text, as HTML with issue references, commit hashes and
+	// the like linked
+	LinkedText template.HTML
+}
+
+// IsCommittedByAuthor returns true when c was committed by its author,
+// rather than applied by someone else.
+func (c Commit) IsCommittedByAuthor() bool {
+	return c.Author == c.Committer && strings.EqualFold(c.AuthorEmail, c.CommitterEmail)
+}
+
+// IsMerge returns true when c has more than one parent.
+func (c Commit) IsMerge() bool {
+	return len(c.ParentHashes) > 1
+}
+
+// The dimensions, in pixels, of a column and a row of a commit graph
+const (
+	graphColumnWidth = 14
+	graphRowHeight   = 24
+)
+
+// GraphColors is the number of colors cycled through by the lanes of
+// a commit graph.
+const GraphColors = 6
+
+// GraphRow is the part of a commit graph, like that drawn by git log
+// --graph, alongside a single commit of a log. Each line of history is
+// a lane, drawn in a column of the graph, which ends at the commit it
+// leads to.
+type GraphRow struct {
+	// The column of the commit's node, numbered from 0
+	Column int
+	// The number of columns in the graph
+	Columns int
+	// The color, less than [GraphColors], of the commit's lane
+	Color int
+	// True if more than one child of the commit precedes it in the
+	// log, which is where history forks
+	Fork bool
+	// The edges drawn in the row
+	Edges []GraphEdge
+}
+
+// Width returns the width of r in pixels.
+func (r GraphRow) Width() int {
+	return r.Columns * graphColumnWidth
+}
+
+// Height returns the height of r in pixels.
+func (r GraphRow) Height() int {
+	return graphRowHeight
+}
+
+// X returns the horizontal position of the commit's node in pixels.
+func (r GraphRow) X() int {
+	return columnX(r.Column)
+}
+
+// Y returns the vertical position of the commit's node in pixels.
+func (r GraphRow) Y() int {
+	return graphRowHeight / 2
+}
+
+// GraphEdge is a line within a [GraphRow], from a lane at the top of
+// the row or the commit's node to a lane at the bottom of the row or
+// the commit's node.
+type GraphEdge struct {
+	// The columns at which the edge starts and ends
+	From, To int
+	// The part of the row spanned by the edge
+	Span EdgeSpan
+	// The color, less than [GraphColors], of the edge's lane
+	Color int
+}
+
+// EdgeSpan describes the part of a [GraphRow] spanned by a
+// [GraphEdge].
+type EdgeSpan uint8
+
+// These are recognized [EdgeSpan] values.
+const (
+	// The edge passes the commit, from the top of the row to the
+	// bottom
+	SpanThrough EdgeSpan = iota
+	// The edge leads from the top of the row into the commit's node
+	SpanIn
+	// The edge leads from the commit's node to the bottom of the row
+	SpanOut
+)
+
+// X1 returns the horizontal position of the start of e in pixels.
+func (e GraphEdge) X1() int {
+	return columnX(e.From)
+}
+
+// Y1 returns the vertical position of the start of e in pixels.
+func (e GraphEdge) Y1() int {
+	if e.Span == SpanOut {
+		return graphRowHeight / 2
+	}
+	return 0
+}
+
+// X2 returns the horizontal position of the end of e in pixels.
+func (e GraphEdge) X2() int {
+	return columnX(e.To)
+}
+
+// Y2 returns the vertical position of the end of e in pixels.
+func (e GraphEdge) Y2() int {
+	if e.Span == SpanIn {
+		return graphRowHeight / 2
+	}
+	return graphRowHeight
+}
+
+func columnX(column int) int {
+	return column*graphColumnWidth + graphColumnWidth/2
+}
+
 // Hash is a Git hash.
 type Hash string
 
@@ -248,8 +588,33 @@ type BlobData struct {
 	Commit Commit
 	// The Blob itself.
 	Blob Blob
-	// If the blob is a Markdown file, rendered content goes here
-	RenderedMarkdown template.HTML
+	// If the blob is a file with a renderer, such as a Markdown
+	// file, rendered content goes here. See
+	// [config.Config.Renderers].
+	Rendered template.HTML
+	// The table of contents of the rendered content.
+	TOC []Heading
+	// True if the blob is larger than [config.Config.MaxBlobSize],
+	// in which case it is neither read nor rendered
+	Truncated bool
+}
+
+// RenderedMarkdown returns b.Rendered.
+//
+// Deprecated: Use the Rendered field.
+func (b BlobData) RenderedMarkdown() template.HTML {
+	return b.Rendered
+}
+
+// Heading is an entry in the table of contents of rendered content.
+type Heading struct {
+	// Level is the heading level, from 1 to 6.
+	Level int
+	// ID is the heading's id attribute, suitable for use as a URL
+	// fragment.
+	ID string
+	// Text is the heading text.
+	Text string
 }
 
 // Blob is information related to a Git blob.
@@ -260,6 +625,15 @@ type Blob struct {
 	Size int64
 	// The contents of the blob
 	Lines []BlobLine
+	// The name of the language used to highlight the blob, empty
+	// if the blob is not highlighted
+	Language string
+}
+
+// IsHighlighted returns true if the lines of b carry highlighted
+// HTML.
+func (b Blob) IsHighlighted() bool {
+	return b.Language != ""
 }
 
 // Blob line contains the line number and contents of a single line in
@@ -267,6 +641,13 @@ type Blob struct {
 type BlobLine struct {
 	Number  int
 	Content string
+	// HTML is the highlighted content of the line, populated when
+	// the blob is highlighted. Each token is wrapped in a span
+	// whose class is the Pygments short name for the token type,
+	// prefixed by "hl-". For example, keywords have class hl-k,
+	// declaration keywords hl-kd, strings hl-s and comments hl-c.
+	// Plain text is not wrapped.
+	HTML template.HTML
 }
 
 // RefsData is provided to the refs template when executed and becomes
@@ -274,10 +655,80 @@ type BlobLine struct {
 type RefsData struct {
 	// The repository
 	Repo Repo
-	// A slice containing all branch references
+	// The name of the default branch, to which HEAD points, empty
+	// if HEAD is detached or names no branch
+	Head string
+	// The filter applied to reference names
+	Query string
+	// The kind of references shown, "branches" or "tags", empty if
+	// both are shown
+	Kind string
+	// The requested order of tags, empty for the repository's
+	// default order
+	Sort string
+	// The order of Tags: "date", newest first; "name"; or
+	// "version", highest first
+	TagSort string
+	// The page number, starting at 1
+	Page int
+	// A slice containing the page of branch references matching
+	// Query, newest first
 	Branches []Reference
-	// A slice containing all tag references
+	// A slice containing the page of tag references matching Query,
+	// in TagSort order
 	Tags []Reference
+	// The numbers of branch and tag references matching Query
+	BranchCount, TagCount int
+	// PrevPage and NextPage link to the previous and next pages of
+	// references. Each is empty if there is no such page.
+	PrevPage, NextPage string
+}
+
+// HasPrev returns true if r.PrevPage is not empty.
+func (r RefsData) HasPrev() bool {
+	return r.PrevPage != ""
+}
+
+// HasNext returns true if r.NextPage is not empty.
+func (r RefsData) HasNext() bool {
+	return r.NextPage != ""
+}
+
+// Link returns a link to the given page of references of the given
+// kind, "branches", "tags" or empty for both, matching r.Query and in
+// the same order.
+func (r RefsData) Link(kind string, page int) string {
+	v := make(url.Values)
+	if r.Query != "" {
+		v.Set("q", r.Query)
+	}
+	if kind != "" {
+		v.Set("kind", kind)
+	}
+	if r.Sort != "" {
+		v.Set("sort", r.Sort)
+	}
+	if page > 1 {
+		v.Set("page", strconv.Itoa(page))
+	}
+	return "?" + v.Encode()
+}
+
+// SortLink returns a link to the first page of references of the same
+// kind, with tags in the given order.
+func (r RefsData) SortLink(sort string) string {
+	r.Sort = sort
+	return r.Link(r.Kind, 1)
+}
+
+// ShowBranches returns true if branch references are shown.
+func (r RefsData) ShowBranches() bool {
+	return r.Kind != "tags"
+}
+
+// ShowTags returns true if tag references are shown.
+func (r RefsData) ShowTags() bool {
+	return r.Kind != "branches"
 }
 
 // Reference contains information pertaining to a Git repository reference.
@@ -287,6 +738,21 @@ type Reference struct {
 	// The time the reference was created or last updated
 	// (whichever is most recent)
 	Time time.Time
+	// The commit at the tip of the reference. For a tag, this is
+	// the commit tagged, and its Hash is empty if a tag names some
+	// other object.
+	Commit Commit
+	// IsHead is true for the default branch.
+	IsHead bool
+	// Ahead and Behind are the numbers of commits on a branch that
+	// are not on the default branch, and on the default branch that
+	// are not on the branch. Both are zero for tags, and for all
+	// references if there is no default branch.
+	Ahead, Behind int
+	// Annotated is true for annotated tags, which are tag objects
+	// with their own tagger and message, rather than bare
+	// references to commits.
+	Annotated bool
 }
 
 // LogData is provided to the log template when executed and becomes
@@ -296,19 +762,83 @@ type LogData struct {
 	Repo Repo
 	// The revision
 	Revision string
-	// The hash from which to begin displaying the log
+	// The hash from which the log is walked. Later pages are walked
+	// from the same hash, so that they are unaffected by new commits
+	// to Revision.
 	FromHash Hash
-	// A slice of Git commit information
+	// The order of the log, either "date" (the default) or "topo"
+	Order string
+	// The active filters: the first and last days, in UTC, of the
+	// commits shown, each zero if not set, and the author name or
+	// email substring
+	Since, Until time.Time
+	Author       string
+	// The current page number, starting at 1
+	Page int
+	// A slice of Git commit information, each with its row of the
+	// commit graph unless the log is filtered
 	Commits []Commit
-	// The hash of the first commit for the next page
-	NextPage Hash
+	// Links to the previous and next pages, empty when there is
+	// no such page
+	PrevPage, NextPage string
+}
+
+// IsTopo returns true if l is in topological order.
+func (l LogData) IsTopo() bool {
+	return l.Order == "topo"
+}
+
+// HasPrev returns true if l.PrevPage is not empty.
+func (l LogData) HasPrev() bool {
+	return l.PrevPage != ""
 }
 
-// HasNext returns true of l.NextPage is not empty.
+// HasNext returns true if l.NextPage is not empty.
 func (l LogData) HasNext() bool {
 	return l.NextPage != ""
 }
 
+// IsFiltered returns true if any of the Since, Until or Author
+// filters are active.
+func (l LogData) IsFiltered() bool {
+	return !l.Since.IsZero() || !l.Until.IsZero() || l.Author != ""
+}
+
+// Link returns a link to the given page of the log, walked from
+// l.FromHash in the same order and with the same filters.
+func (l LogData) Link(page int) string {
+	v := l.query()
+	v.Set("from", string(l.FromHash))
+	if page > 1 {
+		v.Set("page", strconv.Itoa(page))
+	}
+	return "?" + v.Encode()
+}
+
+// OrderLink returns a link to the first page of the log in the given
+// order, retaining the active filters.
+func (l LogData) OrderLink(order string) string {
+	l.Order = order
+	return "?" + l.query().Encode()
+}
+
+func (l LogData) query() url.Values {
+	v := make(url.Values)
+	if l.Order != "" {
+		v.Set("order", l.Order)
+	}
+	if !l.Since.IsZero() {
+		v.Set("since", l.Since.Format(time.DateOnly))
+	}
+	if !l.Until.IsZero() {
+		v.Set("until", l.Until.Format(time.DateOnly))
+	}
+	if l.Author != "" {
+		v.Set("author", l.Author)
+	}
+	return v
+}
+
 // CommitData is provided to the commit template when executed and
 // becomes dot within the template.
 type CommitData struct {
@@ -319,19 +849,265 @@ type CommitData struct {
 	// The commit
 	Commit Commit
 	// The commit diffstat, populated from [object.FileStats.String].
+	// For a combined diff, it counts the lines presented in
+	// Combined.
 	Diffstat string
-	// A slice of file patches
+	// A slice of file patches against the selected parent, or
+	// the first parent if none is selected, populated unless the
+	// diff is combined
 	FilePatches []FilePatch
+	// A slice of combined file patches of a merge against all of
+	// its parents, populated when no parent is selected
+	Combined []CombinedFilePatch
+	// True if file patches beyond [config.Config.MaxDiffFiles] are
+	// omitted
+	Truncated bool
+	// The options used to display the diff
+	DiffOptions
+}
+
+// RawLink returns a link to the raw contents of the file changed by
+// fp, as of c.Commit. It returns an empty string if fp deletes the
+// file.
+func (c CommitData) RawLink(fp FilePatch) string {
+	return rawLink(c.Repo, string(c.Commit.Hash), fp)
+}
+
+// Parents returns the parents of c.Commit, numbered from 1.
+func (c CommitData) Parents() []Parent {
+	parents := make([]Parent, len(c.Commit.ParentHashes))
+	for i, h := range c.Commit.ParentHashes {
+		parents[i] = Parent{Number: i + 1, Hash: h}
+	}
+	return parents
+}
+
+// Parent is a numbered parent of a commit.
+type Parent struct {
+	// The number of the parent, starting at 1
+	Number int
+	// The hash of the parent
+	Hash Hash
+}
+
+// IsCombined returns true if c holds a combined diff of a merge.
+func (c CommitData) IsCombined() bool {
+	return c.Parent == 0 && c.Commit.IsMerge()
+}
+
+// CombinedFilePatch represents the changes to an individual file in a
+// merge relative to all of its parents. Only files differing from
+// every parent are included, and only the hunks of those files that
+// differ from every parent, such as conflict resolutions.
+type CombinedFilePatch struct {
+	// True if the file is binary, otherwise false. Binary files
+	// have no lines.
+	IsBinary bool
+	// The path of the file
+	Path string
+	// Every line of the file, and the lines deleted from its
+	// parents
+	Lines []CombinedLine
+	// The number of context lines presented on either side of a
+	// change
+	Context int
+	// True if the file, or any of its parents, is larger than
+	// [config.Config.MaxBlobSize] or has more lines than
+	// [config.Config.MaxDiffLines], in which case Lines is empty,
+	// or if lines beyond [config.Config.MaxDiffLines] are omitted
+	// from Lines
+	Truncated bool
+}
+
+// Hunks returns the lines of each hunk of cp that differs from every
+// parent, with context lines on either side and separators between
+// non-contiguous hunks. Hunks matching any one parent, such as those
+// merged cleanly, are omitted.
+func (cp CombinedFilePatch) Hunks() []CombinedLine {
+	keep := make([]bool, len(cp.Lines))
+	for start := 0; start < len(cp.Lines); {
+		if cp.Lines[start].Operation() == Equal {
+			start += 1
+			continue
+		}
+		end := start
+		changed := make([]bool, len(cp.Lines[start].Markers))
+		for ; end < len(cp.Lines) && cp.Lines[end].Operation() != Equal; end += 1 {
+			for p, m := range cp.Lines[end].Markers {
+				changed[p] = changed[p] || m != ' '
+			}
+		}
+		if !slices.Contains(changed, false) {
+			for i := max(start-cp.Context, 0); i < min(end+cp.Context, len(cp.Lines)); i += 1 {
+				keep[i] = true
+			}
+		}
+		start = end
+	}
+
+	hunks := make([]CombinedLine, 0)
+	for i, line := range cp.Lines {
+		switch {
+		case keep[i]:
+			hunks = append(hunks, line)
+		case i > 0 && keep[i-1]:
+			hunks = append(hunks, CombinedLine{Content: separator})
+		}
+	}
+	if len(hunks) > 0 && hunks[len(hunks)-1].IsSeparator() {
+		return hunks[:len(hunks)-1]
+	}
+	return hunks
+}
+
+// CombinedLine represents a single line of a [CombinedFilePatch],
+// structured for display within an HTML table.
+type CombinedLine struct {
+	// One marker per parent: "+" if the line is added relative to
+	// the parent, "-" if it is deleted from the parent, otherwise
+	// " "
+	Markers string
+	// The line number in the merge, empty for deleted lines
+	Line string
+	// The content of the line, excluding the markers
+	Content string
+}
+
+// IsSeparator returns true if l separates non-contiguous hunks of a
+// combined file patch.
+func (l CombinedLine) IsSeparator() bool {
+	return l.Markers == "" && l.Content == separator
+}
+
+// Operation returns Delete if l is deleted from any parent, Add if it
+// is added relative to any parent, and otherwise Equal.
+func (l CombinedLine) Operation() Operation {
+	switch {
+	case strings.Contains(l.Markers, "-"):
+		return Delete
+	case strings.Contains(l.Markers, "+"):
+		return Add
+	default:
+		return Equal
+	}
+}
+
+// DiffOptions are the options used to display a diff.
+type DiffOptions struct {
+	// The diff view, either "unified" or "split"
+	View string
+	// True if whitespace changes are ignored
+	IgnoreWhitespace bool
+	// The number of context lines requested, or -1 if the default
+	// is used
+	Context int
+	// The parent of a commit, numbered from 1, against which the
+	// diff is taken, or zero if none is selected
+	Parent int
+}
+
+// IsSplit returns true if the side-by-side diff view is selected.
+func (o DiffOptions) IsSplit() bool {
+	return o.View == "split"
+}
+
+// Link returns a relative link to the current diff with the given
+// view and whitespace option. The other options are retained.
+func (o DiffOptions) Link(view string, ignoreWhitespace bool) string {
+	o.View, o.IgnoreWhitespace = view, ignoreWhitespace
+	return o.link()
+}
+
+// ParentLink returns a relative link to the current commit diffed
+// against parent, numbered from 1, or to its combined diff if parent
+// is zero. The other options are retained.
+func (o DiffOptions) ParentLink(parent int) string {
+	o.Parent = parent
+	return o.link()
+}
+
+func (o DiffOptions) link() string {
+	q := make(url.Values)
+	if o.View != "" {
+		q.Set("view", o.View)
+	}
+	if o.IgnoreWhitespace {
+		q.Set("w", "1")
+	}
+	if o.Context >= 0 {
+		q.Set("context", strconv.Itoa(o.Context))
+	}
+	if o.Parent > 0 {
+		q.Set("parent", strconv.Itoa(o.Parent))
+	}
+	return "?" + q.Encode()
 }
 
 // FilePatch represents the changes to an individual file.
 type FilePatch struct {
 	// True if the file is binary, otherwise false.
 	IsBinary bool
-	// The file name
-	File string
+	// The path of the file before and after the change. OldPath is
+	// empty if the file is added, and NewPath is empty if the file
+	// is deleted.
+	OldPath, NewPath string
+	// The type of change
+	Change ChangeType
+	// The similarity, as a percentage, of a renamed or copied file
+	// to its source, otherwise zero
+	Similarity int
 	// A slice of chunks representing changes to the file
 	Chunks []Chunk
+	// The number of context lines presented on either side of a
+	// change
+	Context int
+	// True if the file is larger than [config.Config.MaxBlobSize],
+	// in which case Chunks is empty, or if lines beyond
+	// [config.Config.MaxDiffLines] are omitted from Chunks
+	Truncated bool
+}
+
+// Path returns the path of the changed file: the new path unless the
+// file is deleted.
+func (fp FilePatch) Path() string {
+	if fp.Change == ChangeDeleted {
+		return fp.OldPath
+	}
+	return fp.NewPath
+}
+
+// ChangeType describes how a [FilePatch] changes its file.
+type ChangeType uint8
+
+// These are recognized [ChangeType] values.
+const (
+	// The file's content is modified in place
+	ChangeModified ChangeType = iota
+	// The file is added
+	ChangeAdded
+	// The file is deleted
+	ChangeDeleted
+	// The file is moved from OldPath to NewPath
+	ChangeRenamed
+	// The file is added at NewPath as a copy of OldPath, which
+	// remains in place
+	ChangeCopied
+)
+
+// String returns a short description of t.
+func (t ChangeType) String() string {
+	switch t {
+	case ChangeAdded:
+		return "added"
+	case ChangeDeleted:
+		return "deleted"
+	case ChangeRenamed:
+		return "renamed"
+	case ChangeCopied:
+		return "copied"
+	default:
+		return "modified"
+	}
 }
 
 var errBinaryPatch = errors.New("cannot print diff for a binary patch")
@@ -387,6 +1163,8 @@ func (fp FilePatch) Info() ([]PatchInfo, error) {
 		}
 	}
 
+	pairSegments(fullInfo)
+
 	inDiff := false
 	for i, lineInfo := range fullInfo {
 		var (
@@ -395,9 +1173,9 @@ func (fp FilePatch) Info() ([]PatchInfo, error) {
 	context:
 		for _, diffLine := range lines {
 			switch {
-			case i < diffLine && (i+DiffContext) >= diffLine,
+			case i < diffLine && (i+fp.Context) >= diffLine,
 				i == diffLine,
-				i > diffLine && (i-DiffContext) <= diffLine:
+				i > diffLine && (i-fp.Context) <= diffLine:
 				lineInContext = true
 				inDiff = true
 				break context
@@ -408,18 +1186,121 @@ func (fp FilePatch) Info() ([]PatchInfo, error) {
 			info = append(info, lineInfo)
 		case false:
 			if inDiff {
-				info = append(info, PatchInfo{Content: ". . ."})
+				info = append(info, PatchInfo{Content: separator})
 				inDiff = false
 			}
 		}
 	}
 
-	if info[len(info)-1].Content == ". . ." {
+	if len(info) > 0 && info[len(info)-1].IsSeparator() {
 		return info[:len(info)-1], nil
 	}
 	return info, nil
 }
 
+// segmentTimeout bounds the time spent computing the intra-line
+// differences of a single pair of lines.
+const segmentTimeout = 50 * time.Millisecond
+
+// pairSegments pairs each run of deleted lines in info with the run
+// of added lines following it, and sets the Segments of each pair to
+// their intra-line differences.
+func pairSegments(info []PatchInfo) {
+	for i := 0; i < len(info); {
+		if info[i].Operation != Delete {
+			i += 1
+			continue
+		}
+		dels := i
+		for i < len(info) && info[i].Operation == Delete {
+			i += 1
+		}
+		adds := i
+		for i < len(info) && info[i].Operation == Add {
+			i += 1
+		}
+		for j := 0; j < min(adds-dels, i-adds); j += 1 {
+			info[dels+j].Segments, info[adds+j].Segments = segments(
+				info[dels+j].Content[1:], info[adds+j].Content[1:])
+		}
+	}
+}
+
+// segments returns the intra-line differences between the old and
+// new versions of a line. If the lines have nothing in common, both
+// returned slices are nil.
+func segments(old, new string) (dels, adds []Segment) {
+	dmp := diffmatchpatch.New()
+	dmp.DiffTimeout = segmentTimeout
+	diffs := dmp.DiffCleanupSemantic(dmp.DiffMain(old, new, false))
+
+	common := false
+	for _, d := range diffs {
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			common = common || strings.TrimSpace(d.Text) != ""
+			dels = append(dels, Segment{Text: d.Text, Operation: Equal})
+			adds = append(adds, Segment{Text: d.Text, Operation: Equal})
+		case diffmatchpatch.DiffDelete:
+			dels = append(dels, Segment{Text: d.Text, Operation: Delete})
+		case diffmatchpatch.DiffInsert:
+			adds = append(adds, Segment{Text: d.Text, Operation: Add})
+		}
+	}
+	if !common {
+		return nil, nil
+	}
+	return dels, adds
+}
+
+// Split converts fp to a slice of SplitInfo, ideal for display of a
+// side-by-side diff within an HTML table. Runs of deleted lines are
+// paired with the added lines that follow them.
+func (fp FilePatch) Split() ([]SplitInfo, error) {
+	info, err := fp.Info()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		split      = make([]SplitInfo, 0, len(info))
+		dels, adds []PatchInfo
+	)
+	flush := func() {
+		for i := 0; i < max(len(dels), len(adds)); i += 1 {
+			var row SplitInfo
+			if i < len(dels) {
+				row.Left = dels[i]
+			}
+			if i < len(adds) {
+				row.Right = adds[i]
+			}
+			split = append(split, row)
+		}
+		dels, adds = dels[:0], adds[:0]
+	}
+	for _, lineInfo := range info {
+		switch {
+		case lineInfo.Operation == Delete:
+			if len(adds) > 0 {
+				flush()
+			}
+			dels = append(dels, lineInfo)
+		case lineInfo.Operation == Add:
+			adds = append(adds, lineInfo)
+		case lineInfo.IsSeparator():
+			flush()
+			split = append(split, SplitInfo{Left: lineInfo, Right: lineInfo})
+		default:
+			flush()
+			left, right := lineInfo, lineInfo
+			left.Right, right.Left = "", ""
+			split = append(split, SplitInfo{Left: left, Right: right})
+		}
+	}
+	flush()
+	return split, nil
+}
+
 // String implements the [fmt.Stringer] interface for FilePatch.
 func (fp FilePatch) String() string {
 	info, err := fp.Info()
@@ -458,6 +1339,22 @@ const (
 	Delete
 )
 
+// String returns the name of o: one of "equal", "add" or "delete".
+func (o Operation) String() string {
+	switch o {
+	case Add:
+		return "add"
+	case Delete:
+		return "delete"
+	default:
+		return "equal"
+	}
+}
+
+// separator is the content of the [PatchInfo] separating
+// non-contiguous lines of a file patch.
+const separator = ". . ."
+
 // PatchInfo represents a single line of a file patch, structured for
 // display within an HTML table.
 type PatchInfo struct {
@@ -467,6 +1364,63 @@ type PatchInfo struct {
 	Operation Operation
 	// The content of the current line
 	Content string
+	// The intra-line differences of a changed line paired with its
+	// counterpart, excluding the leading "+" or "-". Nil if the line
+	// is unchanged or unpaired, or if the pair has nothing in common.
+	Segments []Segment
+}
+
+// Prefix returns the leading " ", "+" or "-" of p's Content.
+func (p PatchInfo) Prefix() string {
+	if p.Content == "" || p.IsSeparator() {
+		return ""
+	}
+	return p.Content[:1]
+}
+
+// Segment is a part of a changed line, as computed by an intra-line
+// diff.
+type Segment struct {
+	// The text of the segment
+	Text string
+	// Equal if the segment is shared by both versions of the line,
+	// otherwise Add or Delete
+	Operation Operation
+}
+
+// IsChanged returns true if s is not shared by both versions of its
+// line.
+func (s Segment) IsChanged() bool {
+	return s.Operation != Equal
+}
+
+// IsSeparator returns true if p separates non-contiguous lines of a
+// file patch.
+func (p PatchInfo) IsSeparator() bool {
+	return p.Left == "" && p.Right == "" && p.Content == separator
+}
+
+// IsBlank returns true if p holds no line. This is the case for one
+// side of a [SplitInfo] when a line is only added or deleted.
+func (p PatchInfo) IsBlank() bool {
+	return p.Left == "" && p.Right == "" && p.Content == ""
+}
+
+// SplitInfo represents a single row of a side-by-side file patch,
+// structured for display within an HTML table.
+type SplitInfo struct {
+	// The lines of the left (old) and right (new) files. Only the
+	// Left line number is set on the left, and only the Right line
+	// number on the right. Either side may be blank when a line is
+	// only added or deleted. When the row is a separator, both
+	// sides are the same separator.
+	Left, Right PatchInfo
+}
+
+// IsSeparator returns true if s separates non-contiguous lines of a
+// file patch.
+func (s SplitInfo) IsSeparator() bool {
+	return s.Left.IsSeparator()
 }
 
 // DiffData is provided to the diff template when executed and becomes
@@ -476,8 +1430,57 @@ type DiffData struct {
 	Repo Repo
 	// The source (from) and destination (to) revision
 	From, To string
+	// The merge base of From and To, from which the diff is taken
+	// when a three-dot range was requested, otherwise empty
+	MergeBase Hash
 	// The diffstat
 	Diffstat string
 	// File patches
 	FilePatches []FilePatch
+	// True if file patches beyond [config.Config.MaxDiffFiles] are
+	// omitted
+	Truncated bool
+	// The options used to display the diff
+	DiffOptions
+}
+
+// Range returns the commit range of d as requested: From...To if the
+// diff is taken from the merge base, otherwise From..To.
+func (d DiffData) Range() string {
+	if d.MergeBase != "" {
+		return d.From + "..." + d.To
+	}
+	return d.From + ".." + d.To
+}
+
+// RawLink returns a link to the raw contents of the file changed by
+// fp, as of d.To. It returns an empty string if fp deletes the file.
+func (d DiffData) RawLink(fp FilePatch) string {
+	return rawLink(d.Repo, d.To, fp)
+}
+
+// CompareData is provided to the compare template when executed and
+// becomes dot within the template. The embedded DiffData holds the
+// diff from From, or from its merge base with To, to To.
+type CompareData struct {
+	DiffData
+	// The commits reachable from To but not from From, newest
+	// first, no more than fit on a page of the log
+	Commits []Commit
+	// The number of commits reachable from To but not from From
+	// (ahead) and from From but not from To (behind)
+	Ahead, Behind int
+}
+
+// MoreCommits returns the number of commits ahead that are not in
+// c.Commits.
+func (c CompareData) MoreCommits() int {
+	return c.Ahead - len(c.Commits)
+}
+
+func rawLink(r Repo, revision string, fp FilePatch) string {
+	if fp.NewPath == "" {
+		return ""
+	}
+	return "/" + path.Join(r.Slug, "-", "raw", revision, fp.NewPath)
 }
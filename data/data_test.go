@@ -3,6 +3,9 @@
 package data
 
 import (
+	"html/template"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -37,3 +40,200 @@ func TestPathElems(t *testing.T) {
 		t.Errorf("expected test, but got %s", elems[0].Base)
 	}
 }
+
+func TestCategories(t *testing.T) {
+	d := IndexData{
+		Repos: []*Repo{
+			{Slug: "a"},
+			{Slug: "b", Category: "tools"},
+			{Slug: "c", Category: "libraries"},
+			{Slug: "d", Category: "tools"},
+		},
+	}
+	categories := d.Categories()
+	if len(categories) != 3 {
+		t.Fatalf("expected 3 categories, but got %d", len(categories))
+	}
+	for i, name := range []string{"libraries", "tools", ""} {
+		if categories[i].Name != name {
+			t.Errorf("expected category %d to be %q, but got %q", i, name, categories[i].Name)
+		}
+	}
+	if len(categories[1].Repos) != 2 {
+		t.Fatalf("expected 2 repos in tools, but got %d", len(categories[1].Repos))
+	}
+	if categories[1].Repos[0].Slug != "b" || categories[1].Repos[1].Slug != "d" {
+		t.Errorf("expected repos b and d in order, but got %s and %s",
+			categories[1].Repos[0].Slug, categories[1].Repos[1].Slug)
+	}
+}
+
+func TestIndexLink(t *testing.T) {
+	d := IndexData{Query: "git tools", Owner: "djmoch", Sort: "name", Page: 2}
+	if link := d.Link(3, d.Sort); link != "?owner=djmoch&page=3&q=git+tools&sort=name" {
+		t.Errorf("unexpected link: %s", link)
+	}
+	if link := d.SortLink("age"); link != "?owner=djmoch&q=git+tools&sort=age" {
+		t.Errorf("unexpected sort link: %s", link)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	fp := FilePatch{
+		Chunks: []Chunk{
+			{Content: "a\nb\n", Type: Equal},
+			{Content: "c\nd\n", Type: Delete},
+			{Content: "C\n", Type: Add},
+			{Content: "e\n", Type: Equal},
+		},
+		Context: 3,
+	}
+	split, err := fp.Split()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []struct {
+		left, right string
+	}{
+		{" a", " a"},
+		{" b", " b"},
+		{"-c", "+C"},
+		{"-d", ""},
+		{" e", " e"},
+	}
+	if len(split) != len(exp) {
+		t.Fatalf("expected %d rows, but got %d", len(exp), len(split))
+	}
+	for i, row := range split {
+		if row.Left.Content != exp[i].left || row.Right.Content != exp[i].right {
+			t.Errorf("row %d: expected %q|%q, but got %q|%q", i,
+				exp[i].left, exp[i].right, row.Left.Content, row.Right.Content)
+		}
+	}
+	if split[2].Left.Left != "3" || split[2].Left.Right != "" {
+		t.Errorf("expected left line number 3 only, but got %q and %q",
+			split[2].Left.Left, split[2].Left.Right)
+	}
+	if !split[3].Right.IsBlank() {
+		t.Error("expected blank right side")
+	}
+}
+
+func TestSegments(t *testing.T) {
+	fp := FilePatch{
+		Chunks: []Chunk{
+			{Content: "the quick fox\nunrelated\n", Type: Delete},
+			{Content: "the slow fox\n", Type: Add},
+		},
+	}
+	info, err := fp.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := [][]Segment{
+		{{"the ", Equal}, {"quick", Delete}, {" fox", Equal}},
+		nil,
+		{{"the ", Equal}, {"slow", Add}, {" fox", Equal}},
+	}
+	if len(info) != len(exp) {
+		t.Fatalf("expected %d lines, but got %d", len(exp), len(info))
+	}
+	for i, lineInfo := range info {
+		if !reflect.DeepEqual(lineInfo.Segments, exp[i]) {
+			t.Errorf("line %d: expected %v, but got %v", i, exp[i], lineInfo.Segments)
+		}
+	}
+
+	if dels, adds := segments("abc", "xyz"); dels != nil || adds != nil {
+		t.Errorf("expected no segments for unrelated lines, but got %v and %v", dels, adds)
+	}
+}
+
+func TestInfoContext(t *testing.T) {
+	fp := FilePatch{
+		Chunks: []Chunk{
+			{Content: "a\nb\nc\n", Type: Equal},
+			{Content: "d\n", Type: Add},
+			{Content: "e\nf\n", Type: Equal},
+		},
+	}
+	for context, exp := range []int{1, 3, 5, 6} {
+		fp.Context = context
+		info, err := fp.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(info) != exp {
+			t.Errorf("context %d: expected %d lines, but got %d", context, exp, len(info))
+		}
+	}
+}
+
+func TestCombinedHunks(t *testing.T) {
+	cp := CombinedFilePatch{
+		Lines: []CombinedLine{
+			{Markers: "  ", Line: "1", Content: "a"},
+			{Markers: "+ ", Line: "2", Content: "clean"},
+			{Markers: "  ", Line: "3", Content: "b"},
+			{Markers: "  ", Line: "4", Content: "c"},
+			{Markers: "  ", Line: "5", Content: "d"},
+			{Markers: "- ", Content: "ours"},
+			{Markers: " -", Content: "theirs"},
+			{Markers: "++", Line: "6", Content: "resolved"},
+			{Markers: "  ", Line: "7", Content: "e"},
+		},
+		Context: 1,
+	}
+	exp := []string{"d", "ours", "theirs", "resolved", "e"}
+	hunks := cp.Hunks()
+	if len(hunks) != len(exp) {
+		t.Fatalf("expected %d lines, but got %d", len(exp), len(hunks))
+	}
+	for i, line := range hunks {
+		if line.Content != exp[i] {
+			t.Errorf("line %d: expected %q, but got %q", i, exp[i], line.Content)
+		}
+	}
+	if hunks[1].Operation() != Delete || hunks[3].Operation() != Add {
+		t.Error("unexpected operations", hunks[1].Operation(), hunks[3].Operation())
+	}
+}
+
+func TestCommitTrailer(t *testing.T) {
+	c := Commit{
+		Message: "Fix bug\n\nReviewed-by: A\nreviewed-BY: B\nSigned-off-by: C\n",
+		Trailers: []Trailer{
+			{Key: "Reviewed-by", Value: "A"},
+			{Key: "reviewed-BY", Value: "B"},
+			{Key: "Signed-off-by", Value: "C"},
+		},
+	}
+	if act := c.Trailer("Reviewed-By"); !reflect.DeepEqual(act, []string{"A", "B"}) {
+		t.Fatal("unexpected reviewers", act)
+	}
+	if act := c.Trailer("Co-authored-by"); act != nil {
+		t.Fatal("expected no co-authors, but got", act)
+	}
+	if c.Subject() != "Fix bug" {
+		t.Fatal("Subject: exp=Fix bug, act=", c.Subject())
+	}
+}
+
+func TestDeprecatedRendered(t *testing.T) {
+	tmpl := template.Must(template.New("").Parse(
+		`{{ if .Tree.HasMarkdownReadme }}{{ .Tree.MarkdownReadme }}{{ end }}{{ .Blob.RenderedMarkdown }}`))
+	var b strings.Builder
+	err := tmpl.Execute(&b, struct {
+		Tree TreeData
+		Blob BlobData
+	}{
+		Tree: TreeData{RenderedReadme: "<h1>readme</h1>"},
+		Blob: BlobData{Rendered: "<p>blob</p>"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "<h1>readme</h1><p>blob</p>"; b.String() != exp {
+		t.Errorf("expected %q, but got %q", exp, b.String())
+	}
+}
@@ -31,7 +31,6 @@ import (
 	"log"
 	"net/http"
 	"path/filepath"
-	"sort"
 	"strings"
 
 	"djmo.ch/dgit/config"
@@ -52,12 +51,24 @@ var funcMap = template.FuncMap{"Humanize": humanize.Time}
 // DGit [http.Handler]:
 //
 //   - Navigating to / serves a list of Git repositories available for
-//     viewing.
+//     viewing. The list may be filtered by name or description
+//     substring, owner and category with the q, owner and category
+//     query parameters, ordered with the sort query parameter (one of
+//     age, name or owner) and paged with the page query parameter.
 //   - Navigating to /{repo} serves the tree of the HEAD ref for the
-//     of {repo}. If the repository contains a README file, it's raw
-//     contents are displayed below the commit tree.
+//     of {repo}. If the repository contains a README file, its
+//     contents are displayed below the commit tree, rendered as HTML
+//     if there is a renderer for its file extension (see
+//     [config.Config.Renderers]).
 //   - Navigating to /{repo}/-/refs displays a list of branches and tags
-//     for repository {repo}.
+//     for repository {repo}, newest first, with the tip commit of
+//     each and how far each branch is ahead of and behind the default
+//     branch. The lists may be filtered by name substring with the q
+//     query parameter, restricted to branches or tags with kind, and
+//     paged with page. Tags are ordered by date, name or version (as
+//     in v1.2.3-rc.1, highest first) as set by the sort query
+//     parameter, or else by the dgit.tagsort option of the
+//     repository's Git config, or else by date.
 //   - Navigating to /{repo}/-/tree/{rev}/{path} displays
 //     the tree for {rev} of {repo} at {path}. If not provided,
 //     {path} defaults to the root of the repository.
@@ -69,12 +80,38 @@ var funcMap = template.FuncMap{"Humanize": humanize.Time}
 //     provided, {path} defaults to the root of the repository.
 //   - Navigating to /{repo}/-/commit/{commit} displays the commit
 //     message and diff for commit {commit} of repository {repo}.
+//     The view query parameter selects a unified or split
+//     (side-by-side) diff, and is remembered in a cookie. Setting
+//     w=1 ignores whitespace changes, and context=N presents N
+//     lines of context around each change. Merges are shown as a
+//     combined diff against all parents unless parent=N selects
+//     the Nth parent.
 //   - Navigating to /{repo}/-/log/{branch} displays summary information
 //     for each commit in the history of branch {branch} in repository
 //     {repo}. When navigating to /{repo}/-/log, callers are redirected
-//     to /{repo}/log/{default branch}.
+//     to /{repo}/log/{default branch}. Commits are listed newest first
+//     alongside a commit graph, or in topological order, as by git log
+//     --topo-order, with order=topo. Each page links to the next and
+//     previous pages of the same walk with the from and page query
+//     parameters. The since and until query parameters (YYYY-MM-DD,
+//     in UTC) limit the log to commits made within those days, and
+//     author to commits whose author name or email contains the
+//     given text.
 //   - Navigating to /{repo}/-/diff/rev1..rev2 displays the diff from {rev1}
-//     to {rev2} of {repo}.
+//     to {rev2} of {repo}. The view, w and context query parameters
+//     are as for commits. Navigating to /{repo}/-/diff/rev1..rev2.diff
+//     serves the same diff as plain text. Where rev1...rev2 is given
+//     in place of rev1..rev2, the diff is taken from the merge base of
+//     {rev1} and {rev2}, showing only the changes made on {rev2}.
+//   - Navigating to /{repo}/-/compare/rev1...rev2 displays the
+//     commits reachable from {rev2} but not from {rev1}, the number
+//     of commits {rev2} is ahead of and behind {rev1}, and the diff
+//     as for /{repo}/-/diff/rev1...rev2. Either range form is
+//     accepted.
+//   - Navigating to /{repo}/-/patch/{commit} serves commit {commit} of
+//     {repo} as an mbox in the format of git format-patch, suitable
+//     for git am. Navigating to /{repo}/-/patch/rev1..rev2 serves
+//     each non-merge commit reachable from {rev2} but not from {rev1}.
 //
 // Where the variable {commit} is used above, it may refer to a commit
 // hash or ref. If the ref is a branch, the commit is the branch's
@@ -135,6 +172,12 @@ func (d *DGit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case "diff":
 		h := middleware.Get(middleware.Repo(d.diffHandler))
 		h(w, req)
+	case "compare":
+		h := middleware.Get(middleware.Repo(d.compareHandler))
+		h(w, req)
+	case "patch":
+		h := middleware.Get(middleware.Repo(d.patchHandler))
+		h(w, req)
 	case "dumbClone":
 		h := middleware.Get(middleware.Repo(d.dumbCloneHandler))
 		h(w, req)
@@ -168,7 +211,7 @@ func (d *DGit) treeHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	treeData, err := convert.ToTreeData(repo, dReq)
+	treeData, err := convert.ToTreeData(repo, dReq, d.Config)
 	if err != nil {
 		if errors.Is(err, convert.ErrDirectoryNotFound) {
 			log.Println(err)
@@ -196,8 +239,14 @@ func (d *DGit) logHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	dReq := r.Context().Value("dReq").(*request.Request)
-	logData, err := convert.ToLogData(repo, dReq)
+	logData, err := convert.ToLogData(repo, dReq, d.Config)
 	if err != nil {
+		if errors.Is(err, convert.ErrPageNotFound) {
+			log.Println(err)
+			w.WriteHeader(http.StatusNotFound)
+			d.displayError(w, "Not found")
+			return
+		}
 		log.Printf("ERROR: failed to extract template data from %s: %v", repo.Slug, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		d.displayError(w, "Internal server error")
@@ -212,8 +261,8 @@ func (d *DGit) logHandler(w http.ResponseWriter, r *http.Request) {
 
 func (d *DGit) rootHandler(w http.ResponseWriter, r *http.Request) {
 	repos := r.Context().Value("repos").([]*repo.Repo)
-	sort.Sort(sort.Reverse(repo.ByLastModified(repos)))
-	indexData := convert.ToIndexData(repos)
+	dReq := r.Context().Value("dReq").(*request.Request)
+	indexData := convert.ToIndexData(repos, dReq, d.Config)
 	t := template.Must(template.New("templates").Funcs(funcMap).
 		ParseFS(d.Config.Templates, "templates/*.tmpl"))
 	if err := t.ExecuteTemplate(w, "index.tmpl", indexData); err != nil {
@@ -229,8 +278,15 @@ func (d *DGit) commitHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	dReq := r.Context().Value("dReq").(*request.Request)
-	commitData, err := convert.ToCommitData(repo, dReq)
+	dReq.View = diffView(w, r, dReq)
+	commitData, err := convert.ToCommitData(repo, dReq, d.Config)
 	if err != nil {
+		if errors.Is(err, convert.ErrParentNotFound) {
+			log.Println(err)
+			w.WriteHeader(http.StatusNotFound)
+			d.displayError(w, "Not found")
+			return
+		}
 		log.Printf("ERROR: failed to extract template data from %s: %v", repo.Slug, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		d.displayError(w, "Internal server error")
@@ -251,7 +307,15 @@ func (d *DGit) diffHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	dReq := r.Context().Value("dReq").(*request.Request)
-	diffData, err := convert.ToDiffData(repo, dReq)
+	if dReq.Plain {
+		pw := &plainWriter{w: w}
+		if err := convert.WriteDiff(pw, repo, dReq, d.Config); err != nil {
+			d.displayPlainError(pw, "diff", repo.Slug, err)
+		}
+		return
+	}
+	dReq.View = diffView(w, r, dReq)
+	diffData, err := convert.ToDiffData(repo, dReq, d.Config)
 	if err != nil {
 		log.Printf("ERROR: failed to extract template data from %s: %v", repo.Slug, err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -265,6 +329,29 @@ func (d *DGit) diffHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (d *DGit) compareHandler(w http.ResponseWriter, r *http.Request) {
+	repo := getRepo(r)
+	if repo == nil {
+		w.WriteHeader(http.StatusNotFound)
+		d.displayError(w, "Repo not found")
+		return
+	}
+	dReq := r.Context().Value("dReq").(*request.Request)
+	dReq.View = diffView(w, r, dReq)
+	compareData, err := convert.ToCompareData(repo, dReq, d.Config)
+	if err != nil {
+		log.Printf("ERROR: failed to extract template data from %s: %v", repo.Slug, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		d.displayError(w, "Internal server error")
+		return
+	}
+	t := template.Must(template.New("templates").Funcs(funcMap).
+		ParseFS(d.Config.Templates, "templates/*.tmpl"))
+	if err = t.ExecuteTemplate(w, "compare.tmpl", compareData); err != nil {
+		log.Printf("ERROR: failed to execute template: %v", err)
+	}
+}
+
 func (d *DGit) blobHandler(w http.ResponseWriter, r *http.Request) {
 	repo := getRepo(r)
 	if repo == nil {
@@ -273,7 +360,7 @@ func (d *DGit) blobHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	dReq := r.Context().Value("dReq").(*request.Request)
-	treeData, err := convert.ToBlobData(repo, dReq)
+	treeData, err := convert.ToBlobData(repo, dReq, d.Config)
 	if err != nil {
 		if errors.Is(err, convert.ErrFileNotFound) {
 			log.Println(err)
@@ -301,7 +388,7 @@ func (d *DGit) rawHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	dReq := r.Context().Value("dReq").(*request.Request)
-	blobData, err := convert.ToBlobData(repo, dReq)
+	rc, err := convert.OpenRaw(repo, dReq)
 	if err != nil {
 		if errors.Is(err, convert.ErrFileNotFound) {
 			log.Println(err)
@@ -309,13 +396,62 @@ func (d *DGit) rawHandler(w http.ResponseWriter, r *http.Request) {
 			d.displayError(w, "Not found")
 			return
 		}
-		log.Printf("ERROR: failed to extract template data from %s: %v", repo.Slug, err)
+		log.Printf("ERROR: failed to open raw blob from %s: %v", repo.Slug, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		d.displayError(w, "Internal server error")
 		return
 	}
-	for _, line := range blobData.Blob.Lines {
-		fmt.Fprintln(w, line.Content)
+	defer rc.Close()
+	if _, err = io.Copy(w, rc); err != nil {
+		log.Printf("ERROR: failed to write raw blob from %s: %v", repo.Slug, err)
+	}
+}
+
+func (d *DGit) patchHandler(w http.ResponseWriter, r *http.Request) {
+	repo := getRepo(r)
+	if repo == nil {
+		w.WriteHeader(http.StatusNotFound)
+		d.displayError(w, "Repo not found")
+		return
+	}
+	dReq := r.Context().Value("dReq").(*request.Request)
+	pw := &plainWriter{w: w}
+	if err := convert.WritePatch(pw, repo, dReq, d.Config); err != nil {
+		d.displayPlainError(pw, "patch", repo.Slug, err)
+	}
+}
+
+// plainWriter streams plain text to a response, setting its content
+// type on the first write, such that an error page may still be
+// displayed if writing fails before it begins.
+type plainWriter struct {
+	w       http.ResponseWriter
+	written bool
+}
+
+func (pw *plainWriter) Write(p []byte) (int, error) {
+	if !pw.written {
+		pw.w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		pw.written = true
+	}
+	return pw.w.Write(p)
+}
+
+// displayPlainError displays an error page for err, returned while
+// writing a plain text patch or diff of the repo with the given slug,
+// unless writing had already begun, in which case err is only logged.
+func (d *DGit) displayPlainError(pw *plainWriter, what, slug string, err error) {
+	switch {
+	case pw.written:
+		log.Printf("ERROR: failed to write %s from %s: %v", what, slug, err)
+	case errors.Is(err, convert.ErrDiffTooLarge):
+		log.Println(err)
+		pw.w.WriteHeader(http.StatusBadRequest)
+		d.displayError(pw.w, "Diff too large")
+	default:
+		log.Printf("ERROR: failed to write %s from %s: %v", what, slug, err)
+		pw.w.WriteHeader(http.StatusInternalServerError)
+		d.displayError(pw.w, "Internal server error")
 	}
 }
 
@@ -326,10 +462,15 @@ func (d *DGit) refsHandler(w http.ResponseWriter, r *http.Request) {
 		d.displayError(w, "Repo not found")
 		return
 	}
-	refsData, err := convert.ToRefsData(repo)
-	sort.Sort(sort.Reverse(convert.ByAge(refsData.Branches)))
-	sort.Sort(sort.Reverse(convert.ByAge(refsData.Tags)))
+	dReq := r.Context().Value("dReq").(*request.Request)
+	refsData, err := convert.ToRefsData(repo, dReq, d.Config)
 	if err != nil {
+		if errors.Is(err, convert.ErrPageNotFound) {
+			log.Println(err)
+			w.WriteHeader(http.StatusNotFound)
+			d.displayError(w, "Not found")
+			return
+		}
 		log.Printf("ERROR: failed to extract template data from %s: %v", repo.Slug, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		d.displayError(w, "Internal server error")
@@ -396,6 +537,31 @@ func (d *DGit) displayError(w http.ResponseWriter, msg string) {
 	}
 }
 
+// diffViewCookie is the name of the cookie remembering the diff view
+// last requested.
+const diffViewCookie = "dgit-diff-view"
+
+// diffView returns the diff view for r: the view requested in the
+// query string, otherwise the view remembered in a cookie, and
+// otherwise "unified". A view requested in the query string is
+// remembered for future requests.
+func diffView(w http.ResponseWriter, r *http.Request, dReq *request.Request) string {
+	if dReq.View != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     diffViewCookie,
+			Value:    dReq.View,
+			Path:     "/",
+			MaxAge:   365 * 24 * 60 * 60,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return dReq.View
+	}
+	if c, err := r.Cookie(diffViewCookie); err == nil && c.Value == "split" {
+		return "split"
+	}
+	return "unified"
+}
+
 func getRepo(r *http.Request) *repo.Repo {
 	ctxRepo := r.Context().Value("repo")
 	if ctxRepo == nil {
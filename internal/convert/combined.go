@@ -0,0 +1,245 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"djmo.ch/dgit/config"
+	"djmo.ch/dgit/data"
+	"djmo.ch/dgit/internal/request"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// combinedPatches returns the combined file patches of the merge c
+// against all of its parents, with the options requested by req. Files
+// beyond the limit set by cfg are not diffed, in which case truncated
+// is true.
+func combinedPatches(c *object.Commit, r *git.Repository, req *request.Request, cfg config.Config) (patches []data.CombinedFilePatch, truncated bool, err error) {
+	var (
+		context  = diffContext(req, cfg)
+		maxFiles = limit(cfg.MaxDiffFiles, config.DefaultMaxDiffFiles)
+		maxLines = limit(cfg.MaxDiffLines, config.DefaultMaxDiffLines)
+		maxSize  = sizeLimit(cfg.MaxBlobSize, config.DefaultMaxBlobSize)
+		key      = func(line string) string { return line }
+	)
+	if req.IgnoreWhitespace {
+		key = whitespaceKey
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, false, fmt.Errorf("error resolving commit tree: %w", err)
+	}
+	parentTrees := make([]*object.Tree, len(c.ParentHashes))
+	for i, ph := range c.ParentHashes {
+		pc, err := r.CommitObject(ph)
+		if err != nil {
+			return nil, false, fmt.Errorf("error resolving parent commit: %w", err)
+		}
+		if parentTrees[i], err = pc.Tree(); err != nil {
+			return nil, false, fmt.Errorf("error resolving parent commit tree: %w", err)
+		}
+	}
+	paths, err := mergedPaths(tree, parentTrees)
+	if err != nil {
+		return nil, false, err
+	}
+
+	patches = make([]data.CombinedFilePatch, 0, min(len(paths), maxFiles))
+	for _, p := range paths {
+		if len(patches) == maxFiles {
+			truncated = true
+			break
+		}
+		cp := data.CombinedFilePatch{Path: p, Context: context}
+		files := make([]*object.File, len(parentTrees)+1)
+		for i, t := range append([]*object.Tree{tree}, parentTrees...) {
+			f, err := t.File(p)
+			switch {
+			case errors.Is(err, object.ErrFileNotFound):
+				// Files deleted by the merge, or absent from a
+				// parent, are diffed as empty.
+				continue
+			case err != nil:
+				return nil, false, fmt.Errorf("error resolving file %s: %w", p, err)
+			}
+			files[i] = f
+			cp.Truncated = cp.Truncated || f.Size > maxSize
+		}
+		if cp.Truncated {
+			patches = append(patches, cp)
+			continue
+		}
+		srcs := make([]string, len(files))
+		for i, f := range files {
+			if f == nil {
+				continue
+			}
+			if cp.IsBinary, err = f.IsBinary(); err != nil || cp.IsBinary {
+				break
+			}
+			if srcs[i], err = f.Contents(); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("error reading file %s: %w", p, err)
+		}
+		if cp.IsBinary {
+			patches = append(patches, cp)
+			continue
+		}
+		// Files with more lines than are shown are not diffed at
+		// all, as the lines shown depend on those after them.
+		for _, src := range srcs {
+			if shown, _ := truncateLines(src, maxLines); len(shown) < len(src) {
+				cp.Truncated = true
+			}
+		}
+		if cp.Truncated {
+			patches = append(patches, cp)
+			continue
+		}
+		cp.Lines = combineLines(srcs[0], srcs[1:], key)
+		if len(cp.Lines) > maxLines {
+			cp.Lines, cp.Truncated = cp.Lines[:maxLines], true
+		}
+		if len(cp.Hunks()) > 0 {
+			patches = append(patches, cp)
+		}
+	}
+	return patches, truncated, nil
+}
+
+// combinedDiffstat returns the file stats of cps, counting the lines
+// of the hunks they present: those added relative to any parent and
+// those deleted from any parent.
+func combinedDiffstat(cps []data.CombinedFilePatch) object.FileStats {
+	stats := make(object.FileStats, len(cps))
+	for i, cp := range cps {
+		stats[i].Name = cp.Path
+		for _, l := range cp.Hunks() {
+			switch {
+			case l.IsSeparator():
+			case l.Operation() == data.Add:
+				stats[i].Addition += 1
+			case l.Operation() == data.Delete:
+				stats[i].Deletion += 1
+			}
+		}
+	}
+	return stats
+}
+
+// mergedPaths returns the sorted paths of the files in tree that
+// differ from every one of parentTrees, and of those deleted from tree
+// that are in all of parentTrees.
+func mergedPaths(tree *object.Tree, parentTrees []*object.Tree) ([]string, error) {
+	counts := make(map[string]int)
+	for _, pt := range parentTrees {
+		changes, err := object.DiffTreeWithOptions(context.Background(), pt, tree, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error comparing trees: %w", err)
+		}
+		for _, c := range changes {
+			switch {
+			case c.To.Name != "" && c.To.TreeEntry.Mode.IsFile():
+				counts[c.To.Name] += 1
+			case c.To.Name == "" && c.From.TreeEntry.Mode.IsFile():
+				counts[c.From.Name] += 1
+			}
+		}
+	}
+	paths := make([]string, 0, len(counts))
+	for p, n := range counts {
+		if n == len(parentTrees) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// lostLine is a line deleted from one or more parents in a combined
+// diff.
+type lostLine struct {
+	content string
+	markers []byte
+}
+
+// combineLines returns every line of a combined diff of src against
+// each of parentSrcs. Identical lines deleted from several parents at
+// the same position are coalesced.
+func combineLines(src string, parentSrcs []string, key func(string) string) []data.CombinedLine {
+	var (
+		n        = len(parentSrcs)
+		lines    = splitLines(src)
+		markers  = make([][]byte, len(lines))
+		lost     = make([][]lostLine, len(lines)+1)
+		noMarker = strings.Repeat(" ", n)
+	)
+	for i := range markers {
+		markers[i] = []byte(noMarker)
+	}
+	for p, parentSrc := range parentSrcs {
+		var (
+			parentLines = splitLines(parentSrc)
+			i, j        int
+		)
+		for _, d := range lineDiffs(parentLines, lines, key) {
+			count := utf8.RuneCountInString(d.Text)
+			switch d.Type {
+			case diffmatchpatch.DiffEqual:
+				i, j = i+count, j+count
+			case diffmatchpatch.DiffInsert:
+				for k := j; k < j+count; k += 1 {
+					markers[k][p] = '+'
+				}
+				j += count
+			case diffmatchpatch.DiffDelete:
+				cursor := 0
+			deleted:
+				for _, line := range parentLines[i : i+count] {
+					for k := cursor; k < len(lost[j]); k += 1 {
+						if lost[j][k].content == line && lost[j][k].markers[p] == ' ' {
+							lost[j][k].markers[p] = '-'
+							cursor = k + 1
+							continue deleted
+						}
+					}
+					ll := lostLine{content: line, markers: []byte(noMarker)}
+					ll.markers[p] = '-'
+					lost[j] = append(lost[j], ll)
+					cursor = len(lost[j])
+				}
+				i += count
+			}
+		}
+	}
+
+	combined := make([]data.CombinedLine, 0, len(lines))
+	for j := 0; j <= len(lines); j += 1 {
+		for _, ll := range lost[j] {
+			combined = append(combined, data.CombinedLine{
+				Markers: string(ll.markers),
+				Content: strings.TrimSuffix(ll.content, "\n"),
+			})
+		}
+		if j < len(lines) {
+			combined = append(combined, data.CombinedLine{
+				Markers: string(markers[j]),
+				Line:    strconv.Itoa(j + 1),
+				Content: strings.TrimSuffix(lines[j], "\n"),
+			})
+		}
+	}
+	return combined
+}
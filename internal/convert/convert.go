@@ -5,42 +5,93 @@
 package convert
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"html/template"
 	"io"
+	"math"
 	"path"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
+	"djmo.ch/dgit/config"
 	"djmo.ch/dgit/data"
+	"djmo.ch/dgit/internal/mailmap"
 	"djmo.ch/dgit/internal/repo"
 	"djmo.ch/dgit/internal/request"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/filemode"
-	"github.com/go-git/go-git/v5/plumbing/format/diff"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/yuin/goldmark"
-	"github.com/yuin/goldmark/extension"
-	"mvdan.cc/xurls/v2"
 )
 
 var (
 	ErrDirectoryNotFound = errors.New("directory not found")
 	ErrFileNotFound      = errors.New("file not found")
+	ErrPageNotFound      = errors.New("page not found")
+	ErrParentNotFound    = errors.New("parent not found")
+	ErrDiffTooLarge      = errors.New("diff too large")
 )
 
-func ToIndexData(repos []*repo.Repo) data.IndexData {
-	d := data.IndexData{Repos: make([]*data.Repo, len(repos))}
-	for i, repo := range repos {
+func ToIndexData(repos []*repo.Repo, req *request.Request, cfg config.Config) data.IndexData {
+	d := data.IndexData{
+		Query:    req.Query,
+		Owner:    req.Owner,
+		Category: req.Category,
+		Sort:     req.Sort,
+		Page:     max(req.Page, 1),
+	}
+	if d.Sort == "" {
+		d.Sort = "age"
+	}
+	repos = filterRepos(repos, req)
+	switch d.Sort {
+	case "name":
+		sort.Sort(repo.ByName(repos))
+	case "owner":
+		sort.Sort(repo.ByOwner(repos))
+	default:
+		sort.Sort(sort.Reverse(repo.ByLastModified(repos)))
+	}
+	d.Total = len(repos)
+
+	pageSize := orDefault(cfg.IndexPageSize, config.DefaultIndexPageSize)
+	start := min((d.Page-1)*pageSize, len(repos))
+	end := min(start+pageSize, len(repos))
+	if d.Page > 1 {
+		d.PrevPage = d.Link(d.Page-1, req.Sort)
+	}
+	if end < len(repos) {
+		d.NextPage = d.Link(d.Page+1, req.Sort)
+	}
+
+	d.Repos = make([]*data.Repo, 0, end-start)
+	for _, repo := range repos[start:end] {
 		ir := toDataRepo(repo)
-		d.Repos[i] = &ir
+		d.Repos = append(d.Repos, &ir)
 	}
 	return d
 }
 
-func ToTreeData(repo *repo.Repo, req *request.Request) (data.TreeData, error) {
+func filterRepos(repos []*repo.Repo, req *request.Request) []*repo.Repo {
+	query := strings.ToLower(req.Query)
+	filtered := make([]*repo.Repo, 0, len(repos))
+	for _, r := range repos {
+		switch {
+		case req.Owner != "" && !strings.EqualFold(r.Owner, req.Owner),
+			req.Category != "" && !strings.EqualFold(r.Category, req.Category),
+			query != "" &&
+				!strings.Contains(strings.ToLower(r.Slug), query) &&
+				!strings.Contains(strings.ToLower(r.Description), query):
+			continue
+		}
+		filtered = append(filtered, r)
+	}
+	return filtered
+}
+
+func ToTreeData(repo *repo.Repo, req *request.Request, cfg config.Config) (data.TreeData, error) {
 	var (
 		t = data.TreeData{
 			RequestData: data.RequestData{
@@ -49,7 +100,7 @@ func ToTreeData(repo *repo.Repo, req *request.Request) (data.TreeData, error) {
 				Revision: req.Revision,
 			},
 		}
-		readmes = make(map[string]plumbing.Hash)
+		readme *object.TreeEntry
 	)
 	hash, err := toCommitHash(req.Revision, repo.R)
 	if err != nil {
@@ -59,10 +110,9 @@ func ToTreeData(repo *repo.Repo, req *request.Request) (data.TreeData, error) {
 	if err != nil {
 		return t, fmt.Errorf("error resolving commit: %w", err)
 	}
-	t.Commit.Hash = data.Hash(c.Hash.String())
-	t.Commit.Author = c.Author.Name
-	t.Commit.Committer = c.Committer.Name
-	t.Commit.Message = c.Message
+	ids := newIdentities(repo, cfg)
+	links := newLinker(repo, cfg)
+	t.Commit = toDataCommit(c, ids, links)
 	gitTree, err := repo.R.TreeObject(c.TreeHash)
 	if err != nil {
 		return t, fmt.Errorf("error resolving commit tree: %w", err)
@@ -110,43 +160,29 @@ func ToTreeData(repo *repo.Repo, req *request.Request) (data.TreeData, error) {
 				t.Revision, req.Path, entry.Name)),
 		}
 		t.Tree.Entries[i] = te
-		switch entry.Name {
-		case "README", "README.md", "README.rst":
-			readmes[entry.Name] = entry.Hash
+		if mode != data.Dir && isReadme(entry.Name) &&
+			(readme == nil || readmeRank(cfg, entry.Name) < readmeRank(cfg, readme.Name)) {
+			readme = &gitTree.Entries[i]
 		}
 	}
 
-	if len(readmes) > 0 {
-		var (
-			hash       plumbing.Hash
-			tmp        plumbing.Hash
-			ok         bool
-			isMarkdown bool
-		)
-		// least preferred first
-		tmp, ok = readmes["README.rst"]
-		if ok {
-			hash = tmp
-		}
-		tmp, ok = readmes["README"]
-		if ok {
-			hash = tmp
-		}
-		tmp, ok = readmes["README.md"]
-		if ok {
-			hash = tmp
-			isMarkdown = true
-		}
-		contents, err := readBlobContents(hash, repo.R)
+	if readme != nil {
+		contents, err := readBlobContents(readme.Hash, repo.R)
 		if err != nil {
 			return t, err
 		}
-		if isMarkdown {
-			mkd, err := renderMarkdown(contents)
+		t.ReadmeName = readme.Name
+		if render := lookupRenderer(cfg, readme.Name); render != nil {
+			html, err := render(config.RenderFile{
+				Repo:     repo.Slug,
+				Revision: req.Revision,
+				Path:     path.Join(req.Path, readme.Name),
+			}, []byte(contents))
 			if err != nil {
-				return t, fmt.Errorf("error rendering markdown: %w", err)
+				return t, fmt.Errorf("error rendering %s: %w", readme.Name, err)
 			}
-			t.MarkdownReadme = mkd
+			t.RenderedReadme = html
+			t.TOC = tableOfContents(html)
 		} else {
 			t.Readme = contents
 		}
@@ -154,7 +190,7 @@ func ToTreeData(repo *repo.Repo, req *request.Request) (data.TreeData, error) {
 	return t, nil
 }
 
-func ToBlobData(repo *repo.Repo, req *request.Request) (data.BlobData, error) {
+func ToBlobData(repo *repo.Repo, req *request.Request, cfg config.Config) (data.BlobData, error) {
 	b := data.BlobData{
 		RequestData: data.RequestData{
 			Repo:     toDataRepo(repo),
@@ -170,10 +206,9 @@ func ToBlobData(repo *repo.Repo, req *request.Request) (data.BlobData, error) {
 	if err != nil {
 		return b, fmt.Errorf("error resolving commit: %w", err)
 	}
-	b.Commit.Hash = data.Hash(c.Hash.String())
-	b.Commit.Author = c.Author.Name
-	b.Commit.Committer = c.Committer.Name
-	b.Commit.Message = c.Message
+	ids := newIdentities(repo, cfg)
+	links := newLinker(repo, cfg)
+	b.Commit = toDataCommit(c, ids, links)
 	f, err := c.File(req.Path)
 	if err != nil {
 		if errors.Is(err, object.ErrFileNotFound) {
@@ -183,30 +218,79 @@ func ToBlobData(repo *repo.Repo, req *request.Request) (data.BlobData, error) {
 	}
 	b.Blob.Hash = f.Hash.String()
 	b.Blob.Size = f.Size
+	if f.Size > sizeLimit(cfg.MaxBlobSize, config.DefaultMaxBlobSize) {
+		b.Truncated = true
+		return b, nil
+	}
 	lines, err := f.Lines()
 	if err != nil {
 		return b, fmt.Errorf("error getting file lines: %w", err)
 	}
 	b.Blob.Lines = toBlobLines(lines)
-	if strings.HasSuffix(req.Path, ".md") || strings.HasSuffix(req.Path, ".mkd") {
-		mdStr, err := f.Contents()
+	if err = highlightBlob(&b.Blob, f, cfg); err != nil {
+		return b, err
+	}
+	if render := lookupRenderer(cfg, req.Path); render != nil {
+		contents, err := f.Contents()
 		if err != nil {
 			return b, fmt.Errorf("error getting file contents: %w", err)
 		}
-		mkd, err := renderMarkdown(mdStr)
+		html, err := render(config.RenderFile{
+			Repo:     repo.Slug,
+			Revision: req.Revision,
+			Path:     req.Path,
+		}, []byte(contents))
 		if err != nil {
-			return b, fmt.Errorf("error rendering markdown: %w", err)
+			return b, fmt.Errorf("error rendering %s: %w", req.Path, err)
 		}
-		b.RenderedMarkdown = mkd
+		b.Rendered = html
+		b.TOC = tableOfContents(html)
 	}
 	return b, nil
 }
 
-func ToRefsData(repo *repo.Repo) (data.RefsData, error) {
+// OpenRaw opens the raw contents of the blob at req.Path as of
+// req.Revision for reading. Unlike [ToBlobData], the contents are not
+// read into memory, and no limit is placed on their size.
+func OpenRaw(repo *repo.Repo, req *request.Request) (io.ReadCloser, error) {
+	hash, err := toCommitHash(req.Revision, repo.R)
+	if err != nil {
+		return nil, err
+	}
+	c, err := repo.R.CommitObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving commit: %w", err)
+	}
+	f, err := c.File(req.Path)
+	if err != nil {
+		if errors.Is(err, object.ErrFileNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrFileNotFound, req.Path)
+		}
+		return nil, fmt.Errorf("error resolving file: %w", err)
+	}
+	rc, err := f.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %w", err)
+	}
+	return rc, nil
+}
+
+func ToRefsData(repo *repo.Repo, req *request.Request, cfg config.Config) (data.RefsData, error) {
+	pageSize := orDefault(cfg.RefsPageSize, config.DefaultRefsPageSize)
 	r := data.RefsData{
-		Repo: toDataRepo(repo),
-		Tags: make([]data.Reference, 0),
+		Repo:  toDataRepo(repo),
+		Query: req.Query,
+		Kind:  req.Kind,
+		Sort:  req.Sort,
+		Page:  max(req.Page, 1),
 	}
+	var headRef plumbing.ReferenceName
+	if head, err := repo.R.Reference(plumbing.HEAD, false); err == nil &&
+		head.Type() == plumbing.SymbolicReference && head.Target().IsBranch() {
+		headRef = head.Target()
+	}
+
+	var branches, tags []data.Reference
 	// TODO(dmoch): repo.R.References() might be cleaner
 	bIter, err := repo.R.Branches()
 	if err != nil {
@@ -214,11 +298,19 @@ func ToRefsData(repo *repo.Repo) (data.RefsData, error) {
 	}
 	defer bIter.Close()
 	if err := bIter.ForEach(func(ref *plumbing.Reference) error {
+		if !matchRef(ref, req.Query) {
+			return nil
+		}
 		if object, err := repo.R.CommitObject(ref.Hash()); err == nil {
-			r.Branches = append(r.Branches, data.Reference{
-				Name: path.Base(string(ref.Name())),
-				Time: object.Committer.When,
+			branches = append(branches, data.Reference{
+				Name:   path.Base(string(ref.Name())),
+				Time:   object.Committer.When,
+				Commit: data.Commit{Hash: data.Hash(object.Hash.String())},
+				IsHead: ref.Name() == headRef,
 			})
+			if ref.Name() == headRef {
+				r.Head = path.Base(string(ref.Name()))
+			}
 			return nil
 		}
 		return fmt.Errorf("error resolving branch %s: %w", ref, err)
@@ -232,17 +324,26 @@ func ToRefsData(repo *repo.Repo) (data.RefsData, error) {
 	}
 	defer tIter.Close()
 	if err := tIter.ForEach(func(ref *plumbing.Reference) error {
+		if !matchRef(ref, req.Query) {
+			return nil
+		}
 		if object, err := repo.R.TagObject(ref.Hash()); err == nil {
-			r.Tags = append(r.Tags, data.Reference{
-				Name: path.Base(string(ref.Name())),
-				Time: object.Tagger.When,
-			})
+			tag := data.Reference{
+				Name:      path.Base(string(ref.Name())),
+				Time:      object.Tagger.When,
+				Annotated: true,
+			}
+			if object.TargetType == plumbing.CommitObject {
+				tag.Commit.Hash = data.Hash(object.Target.String())
+			}
+			tags = append(tags, tag)
 			return nil
 		}
 		if object, err := repo.R.CommitObject(ref.Hash()); err == nil {
-			r.Tags = append(r.Tags, data.Reference{
-				Name: path.Base(string(ref.Name())),
-				Time: object.Committer.When,
+			tags = append(tags, data.Reference{
+				Name:   path.Base(string(ref.Name())),
+				Time:   object.Committer.When,
+				Commit: data.Commit{Hash: data.Hash(object.Hash.String())},
 			})
 			return nil
 		}
@@ -250,15 +351,105 @@ func ToRefsData(repo *repo.Repo) (data.RefsData, error) {
 	}); err != nil {
 		return r, fmt.Errorf("error enumerating tags: %w", err)
 	}
+	r.TagSort = tagSort(repo, req)
+	sortRefs(branches, "date")
+	sortRefs(tags, r.TagSort)
+
+	var moreBranches, moreTags bool
+	r.BranchCount, r.TagCount = len(branches), len(tags)
+	if r.ShowBranches() {
+		r.Branches, moreBranches = pageRefs(branches, r.Page, pageSize)
+	}
+	if r.ShowTags() {
+		r.Tags, moreTags = pageRefs(tags, r.Page, pageSize)
+	}
+	if r.Page > 1 && len(r.Branches) == 0 && len(r.Tags) == 0 {
+		return r, fmt.Errorf("%w: refs page %d", ErrPageNotFound, r.Page)
+	}
+	if r.Page > 1 {
+		r.PrevPage = r.Link(r.Kind, r.Page-1)
+	}
+	if moreBranches || moreTags {
+		r.NextPage = r.Link(r.Kind, r.Page+1)
+	}
 
+	ids := newIdentities(repo, cfg)
+	links := newLinker(repo, cfg)
+	for _, refs := range [][]data.Reference{r.Branches, r.Tags} {
+		for i := range refs {
+			if refs[i].Commit.Hash == "" {
+				continue
+			}
+			c, err := repo.R.CommitObject(plumbing.NewHash(string(refs[i].Commit.Hash)))
+			if err != nil {
+				return r, fmt.Errorf("error resolving commit of %s: %w", refs[i].Name, err)
+			}
+			refs[i].Commit = toDataCommit(c, ids, links)
+		}
+	}
+
+	if headRef == "" || len(r.Branches) == 0 {
+		return r, nil
+	}
+	head, err := repo.R.Reference(headRef, true)
+	if err != nil {
+		return r, nil
+	}
+	for i, b := range r.Branches {
+		if b.IsHead {
+			continue
+		}
+		hash := plumbing.NewHash(string(b.Commit.Hash))
+		ahead, behind, err := divergence(repo.R, hash, head.Hash())
+		if err != nil {
+			return r, err
+		}
+		r.Branches[i].Ahead, r.Branches[i].Behind = len(ahead), len(behind)
+	}
 	return r, nil
 }
 
-func ToLogData(repo *repo.Repo, req *request.Request) (data.LogData, error) {
+// tagSorts are the orders in which tags may be sorted, the first being
+// the default.
+var tagSorts = []string{"date", "name", "version"}
+
+// tagSort returns the order in which tags are sorted: that requested,
+// or that set by the dgit.tagsort option of the repository's Git
+// config, or by date.
+func tagSort(repo *repo.Repo, req *request.Request) string {
+	if req.Sort != "" {
+		return req.Sort
+	}
+	if order := strings.ToLower(repo.Options["tagsort"]); slices.Contains(tagSorts, order) {
+		return order
+	}
+	return tagSorts[0]
+}
+
+// matchRef returns true if the short name of ref contains query,
+// without regard to case.
+func matchRef(ref *plumbing.Reference, query string) bool {
+	return strings.Contains(strings.ToLower(ref.Name().Short()), strings.ToLower(query))
+}
+
+// pageRefs returns the given page of refs, each page holding size
+// refs, and whether there are later pages.
+func pageRefs(refs []data.Reference, page, size int) ([]data.Reference, bool) {
+	start := min((page-1)*size, len(refs))
+	end := min(start+size, len(refs))
+	return refs[start:end], end < len(refs)
+}
+
+func ToLogData(repo *repo.Repo, req *request.Request, cfg config.Config) (data.LogData, error) {
+	pageSize := orDefault(cfg.LogPageSize, config.DefaultLogPageSize)
 	l := data.LogData{
 		Repo:     toDataRepo(repo),
 		Revision: req.Revision,
-		Commits:  make([]data.Commit, 0, data.LogPageSize),
+		Order:    req.Order,
+		Since:    req.Since,
+		Until:    req.Until,
+		Author:   req.Author,
+		Page:     max(req.Page, 1),
 	}
 	l.FromHash = req.From
 	if req.From == "" {
@@ -268,46 +459,138 @@ func ToLogData(repo *repo.Repo, req *request.Request) (data.LogData, error) {
 		}
 		l.FromHash = data.Hash(hash.String())
 	}
-	lo := &git.LogOptions{
-		From:  plumbing.NewHash(string(l.FromHash)),
-		Order: git.LogOrderCommitterTime,
+	ids := newIdentities(repo, cfg)
+	links := newLinker(repo, cfg)
+	// The commits of earlier pages are walked to skip them, and to
+	// lay out the lanes of the graph leading into the current page.
+	// A page too large to count to is past the end of any log.
+	if l.Page > (math.MaxInt-1)/pageSize {
+		return l, fmt.Errorf("%w: log page %d", ErrPageNotFound, l.Page)
 	}
-	gl, err := repo.R.Log(lo)
+	start := (l.Page - 1) * pageSize
+	commits, err := logCommits(repo.R, plumbing.NewHash(string(l.FromHash)), start+pageSize+1, toLogFilter(req, ids.mailmap))
 	if err != nil {
-		return l, fmt.Errorf("error getting log: %w", err)
+		return l, err
+	}
+	if start >= len(commits) && l.Page > 1 {
+		return l, fmt.Errorf("%w: log page %d", ErrPageNotFound, l.Page)
+	}
+	// Only the hashes of the commits of other pages are converted,
+	// as only the graph needs them.
+	var (
+		all = make([]data.Commit, len(commits))
+		end = min(start+pageSize, len(commits))
+	)
+	for i, c := range commits {
+		if i < start || i >= end {
+			all[i] = data.Commit{Hash: data.Hash(c.Hash.String()), ParentHashes: toDataHashes(c.ParentHashes)}
+			continue
+		}
+		all[i] = toDataCommit(c, ids, links)
+		all[i].Message = strings.Split(c.Message, "\n")[0]
+	}
+	l.Commits = all[start:end]
+	// A filtered log omits the commits joining those shown, so no
+	// graph is drawn.
+	if !l.IsFiltered() {
+		setGraph(all)
+		alignGraph(l.Commits)
+	}
+	if l.Page > 1 {
+		l.PrevPage = l.Link(l.Page - 1)
+	}
+	if len(all) > start+pageSize {
+		l.NextPage = l.Link(l.Page + 1)
+	}
+	return l, nil
+}
+
+// logFilter selects the commits of a log, and their order.
+type logFilter struct {
+	// True for topological order, otherwise by committer time
+	topo bool
+	// The earliest and latest committer times, each ignored if zero
+	since, until time.Time
+	// A lower-case substring of the author's name or email, ignored
+	// if empty
+	author string
+	// The mailmap applied to authors before they are matched, which
+	// may be nil
+	mailmap *mailmap.Mailmap
+}
+
+// toLogFilter returns the logFilter requested by req, matching authors
+// as mapped by mm. The Until day is included in full.
+func toLogFilter(req *request.Request, mm *mailmap.Mailmap) logFilter {
+	f := logFilter{
+		topo:    req.Order == "topo",
+		since:   req.Since,
+		author:  strings.ToLower(req.Author),
+		mailmap: mm,
+	}
+	if !req.Until.IsZero() {
+		f.until = req.Until.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return f
+}
+
+// matchAuthor returns true if the author of c matches f.
+func (f logFilter) matchAuthor(c *object.Commit) bool {
+	if f.author == "" {
+		return true
+	}
+	name, email := f.mailmap.Map(c.Author.Name, c.Author.Email)
+	return strings.Contains(strings.ToLower(name), f.author) ||
+		strings.Contains(strings.ToLower(email), f.author)
+}
+
+// match returns true if c is selected by f.
+func (f logFilter) match(c *object.Commit) bool {
+	when := c.Committer.When
+	return (f.since.IsZero() || !when.Before(f.since)) &&
+		(f.until.IsZero() || !when.After(f.until)) &&
+		f.matchAuthor(c)
+}
+
+// logCommits returns at most n commits reachable from the commit with
+// the given hash and selected by f, in the order selected by f.
+func logCommits(r *git.Repository, from plumbing.Hash, n int, f logFilter) ([]*object.Commit, error) {
+	if f.topo {
+		return topoLog(r, from, n, f.match)
+	}
+	lo := &git.LogOptions{From: from, Order: git.LogOrderCommitterTime}
+	if !f.since.IsZero() {
+		lo.Since = &f.since
+	}
+	if !f.until.IsZero() {
+		lo.Until = &f.until
+	}
+	gl, err := r.Log(lo)
+	if err != nil {
+		return nil, fmt.Errorf("error getting log: %w", err)
 	}
 	defer gl.Close()
-	for i := 0; i < data.LogPageSize; i += 1 {
+	var commits []*object.Commit
+	for len(commits) < n {
 		c, err := gl.Next()
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				break
 			}
-			return l, fmt.Errorf("error getting commit from log: %w", err)
-		}
-		commit := data.Commit{
-			Hash:      data.Hash(c.Hash.String()),
-			Author:    c.Author.Name,
-			Committer: c.Committer.Name,
-			Message:   strings.Split(c.Message, "\n")[0],
-			Time:      c.Committer.When,
+			return nil, fmt.Errorf("error getting commit from log: %w", err)
 		}
-		commit.ParentHashes = make([]data.Hash, len(c.ParentHashes))
-		for i, ph := range c.ParentHashes {
-			commit.ParentHashes[i] = data.Hash(ph.String())
+		if f.matchAuthor(c) {
+			commits = append(commits, c)
 		}
-		l.Commits = append(l.Commits, commit)
 	}
-	if len(l.Commits) == data.LogPageSize && l.Commits[data.LogPageSize-1].HasParents() {
-		l.NextPage = l.Commits[data.LogPageSize-1].ParentHashes[0]
-	}
-	return l, nil
+	return commits, nil
 }
 
-func ToCommitData(repo *repo.Repo, req *request.Request) (data.CommitData, error) {
+func ToCommitData(repo *repo.Repo, req *request.Request, cfg config.Config) (data.CommitData, error) {
 	c := data.CommitData{
-		Repo:     toDataRepo(repo),
-		Revision: req.Revision,
+		Repo:        toDataRepo(repo),
+		Revision:    req.Revision,
+		DiffOptions: toDiffOptions(req),
 	}
 	hash, err := toCommitHash(req.Revision, repo.R)
 	if err != nil {
@@ -317,92 +600,178 @@ func ToCommitData(repo *repo.Repo, req *request.Request) (data.CommitData, error
 	if err != nil {
 		return c, fmt.Errorf("error resolving commit: %w", err)
 	}
-	c.Commit.Hash = data.Hash(gc.Hash.String())
-	c.Commit.Author = gc.Author.Name
-	c.Commit.Committer = gc.Committer.Name
-	c.Commit.Message = gc.Message
-	c.Commit.Time = gc.Committer.When
-	fileStats, err := gc.Stats()
+	ids := newIdentities(repo, cfg)
+	links := newLinker(repo, cfg)
+	c.Commit = toDataCommit(gc, ids, links)
+	notesRefs := cfg.NotesRefs
+	if notesRefs == nil {
+		notesRefs = []string{config.DefaultNotesRef}
+	}
+	notes, err := loadNotes(repo.R, notesRefs)
 	if err != nil {
-		return c, fmt.Errorf("error getting stats for commit: %w", err)
+		return c, err
+	}
+	if c.Commit.Notes, err = commitNotes(repo.R, notes, gc.Hash, links); err != nil {
+		return c, err
 	}
-	c.Diffstat = fileStats.String()
-	c.Commit.ParentHashes = make([]data.Hash, len(gc.ParentHashes))
-	for i, ph := range gc.ParentHashes {
-		c.Commit.ParentHashes[i] = data.Hash(ph.String())
+	tree, err := gc.Tree()
+	if err != nil {
+		return c, fmt.Errorf("error resolving commit tree: %w", err)
+	}
+	if req.Parent > len(gc.ParentHashes) {
+		return c, fmt.Errorf("%w: commit %s has no parent %d", ErrParentNotFound, gc.Hash, req.Parent)
 	}
-	switch len(gc.ParentHashes) {
-	case 0:
-		files, err := gc.Files()
-		c.FilePatches = make([]data.FilePatch, 0)
+	if c.IsCombined() {
+		c.Combined, c.Truncated, err = combinedPatches(gc, repo.R, req, cfg)
 		if err != nil {
-			return c, fmt.Errorf("error getting commit files: %w", err)
+			return c, fmt.Errorf("error generating combined diff: %w", err)
 		}
-		if err = files.ForEach(func(f *object.File) error {
-			isBinary, err := f.IsBinary()
-			if err != nil {
-				return fmt.Errorf("IsBinary error: %w", err)
-			}
-			contents, err := f.Contents()
-			if err != nil {
-				return fmt.Errorf("contents error: %w", err)
-			}
-			fp := data.FilePatch{
-				IsBinary: isBinary,
-				File:     f.Name + " (created)",
-				Chunks: []data.Chunk{
-					{Content: contents, Type: data.Add},
-				},
-			}
-			c.FilePatches = append(c.FilePatches, fp)
-			return nil
-		}); err != nil {
-			return c, fmt.Errorf("error reading commit files: %w", err)
-		}
-	default:
-		pcHash := gc.ParentHashes[0]
-		pc, err := repo.R.CommitObject(pcHash)
+		c.Diffstat = combinedDiffstat(c.Combined).String()
+		return c, nil
+	}
+	var parentTree *object.Tree
+	if len(gc.ParentHashes) > 0 {
+		pc, err := repo.R.CommitObject(gc.ParentHashes[max(req.Parent, 1)-1])
 		if err != nil {
 			return c, fmt.Errorf("error resolving parent commit: %w", err)
 		}
-		patch, err := pc.Patch(gc)
-		if err != nil {
-			return c, fmt.Errorf("error resolving commit patch: %w", err)
+		if parentTree, err = pc.Tree(); err != nil {
+			return c, fmt.Errorf("error resolving parent commit tree: %w", err)
 		}
-		c.FilePatches = toFilePatches(patch.FilePatches())
+	}
+	c.FilePatches, c.Diffstat, c.Truncated, err = diffTrees(parentTree, tree, repo.R, req, cfg)
+	if err != nil {
+		return c, err
 	}
 	return c, nil
 }
 
-func ToDiffData(repo *repo.Repo, req *request.Request) (data.DiffData, error) {
+func ToDiffData(repo *repo.Repo, req *request.Request, cfg config.Config) (data.DiffData, error) {
+	_, to, base, err := rangeCommits(repo.R, req)
+	if err != nil {
+		return data.DiffData{}, err
+	}
+	return toDiffData(repo, req, cfg, base, to)
+}
+
+// toDiffData returns the diff data of req, diffing from base to to.
+func toDiffData(repo *repo.Repo, req *request.Request, cfg config.Config, base, to *object.Commit) (data.DiffData, error) {
 	d := data.DiffData{
-		Repo: toDataRepo(repo),
-		From: req.DiffFrom,
-		To:   req.DiffTo,
+		Repo:        toDataRepo(repo),
+		From:        req.DiffFrom,
+		To:          req.DiffTo,
+		DiffOptions: toDiffOptions(req),
+	}
+	if req.MergeBase {
+		d.MergeBase = data.Hash(base.Hash.String())
 	}
-	hash, err := repo.R.ResolveRevision(plumbing.Revision(req.DiffFrom))
+	fromTree, err := base.Tree()
 	if err != nil {
-		return d, fmt.Errorf("error resolving 'from' commit hash: %w", err)
+		return d, fmt.Errorf("error resolving 'from' tree: %w", err)
 	}
-	fromCommit, err := repo.R.CommitObject(*hash)
+	toTree, err := to.Tree()
 	if err != nil {
-		return d, fmt.Errorf("error resolving 'from' commit: %w", err)
+		return d, fmt.Errorf("error resolving 'to' tree: %w", err)
 	}
-	hash, err = repo.R.ResolveRevision(plumbing.Revision(req.DiffTo))
+	d.FilePatches, d.Diffstat, d.Truncated, err = diffTrees(fromTree, toTree, repo.R, req, cfg)
+	return d, err
+}
+
+// ToCompareData converts a [repo.Repo] to a [data.CompareData]. The
+// diff is as for [ToDiffData]. No more commits are listed than fit on
+// a page of the log.
+func ToCompareData(repo *repo.Repo, req *request.Request, cfg config.Config) (data.CompareData, error) {
+	var c data.CompareData
+	from, to, base, err := rangeCommits(repo.R, req)
 	if err != nil {
-		return d, fmt.Errorf("error resolving 'to' commit hash: %w", err)
+		return c, err
 	}
-	toCommit, err := repo.R.CommitObject(*hash)
+	if c.DiffData, err = toDiffData(repo, req, cfg, base, to); err != nil {
+		return c, err
+	}
+	ahead, behind, err := divergence(repo.R, to.Hash, from.Hash)
 	if err != nil {
-		return d, fmt.Errorf("error resolving 'to' commit: %w", err)
+		return c, err
+	}
+	c.Ahead, c.Behind = len(ahead), len(behind)
+	ids := newIdentities(repo, cfg)
+	links := newLinker(repo, cfg)
+	ahead = ahead[:min(len(ahead), orDefault(cfg.LogPageSize, config.DefaultLogPageSize))]
+	c.Commits = make([]data.Commit, len(ahead))
+	for i, hash := range ahead {
+		gc, err := repo.R.CommitObject(hash)
+		if err != nil {
+			return c, fmt.Errorf("error resolving commit: %w", err)
+		}
+		c.Commits[i] = toDataCommit(gc, ids, links)
+		c.Commits[i].Message = strings.Split(gc.Message, "\n")[0]
+	}
+	return c, nil
+}
+
+// rangeCommits resolves the commits at either end of the range
+// requested by req, and base, from which the range is diffed: from, or
+// if req.MergeBase is true, the merge base of from and to.
+func rangeCommits(r *git.Repository, req *request.Request) (from, to, base *object.Commit, err error) {
+	hash, err := r.ResolveRevision(plumbing.Revision(req.DiffFrom))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error resolving 'from' commit hash: %w", err)
+	}
+	if from, err = r.CommitObject(*hash); err != nil {
+		return nil, nil, nil, fmt.Errorf("error resolving 'from' commit: %w", err)
+	}
+	hash, err = r.ResolveRevision(plumbing.Revision(req.DiffTo))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error resolving 'to' commit hash: %w", err)
+	}
+	if to, err = r.CommitObject(*hash); err != nil {
+		return nil, nil, nil, fmt.Errorf("error resolving 'to' commit: %w", err)
+	}
+	if !req.MergeBase {
+		return from, to, from, nil
 	}
-	patch, err := fromCommit.Patch(toCommit)
+	bases, err := from.MergeBase(to)
 	if err != nil {
-		return d, fmt.Errorf("error generating patch: %w", err)
+		return nil, nil, nil, fmt.Errorf("error finding merge base: %w", err)
 	}
-	d.Diffstat = patch.Stats().String()
-	d.FilePatches = toFilePatches(patch.FilePatches())
-	return d, nil
+	if len(bases) == 0 {
+		return nil, nil, nil, fmt.Errorf("no merge base of %s and %s", req.DiffFrom, req.DiffTo)
+	}
+	return from, to, bases[0], nil
+}
+
+// toDataCommit converts an [object.Commit] to a [data.Commit], with
+// identities converted by ids and its message linked by links.
+func toDataCommit(c *object.Commit, ids identities, links *linker) data.Commit {
+	commit := data.Commit{
+		Hash:       data.Hash(c.Hash.String()),
+		AuthorTime: c.Author.When,
+		Message:    c.Message,
+		Time:       c.Committer.When,
+	}
+	commit.Body, commit.Trailers = splitTrailers(c.Message)
+	commit.LinkedSubject = links.link(commit.Subject())
+	commit.LinkedBody = links.link(commit.Body)
+	for i, t := range commit.Trailers {
+		commit.Trailers[i].LinkedValue = links.link(t.Value)
+	}
+	commit.Author, commit.AuthorEmail = ids.mailmap.Map(c.Author.Name, c.Author.Email)
+	commit.Committer, commit.CommitterEmail = ids.mailmap.Map(c.Committer.Name, c.Committer.Email)
+	if ids.avatars != nil {
+		commit.AuthorAvatar = ids.avatars(commit.AuthorEmail)
+		commit.CommitterAvatar = ids.avatars(commit.CommitterEmail)
+	}
+	commit.ParentHashes = toDataHashes(c.ParentHashes)
+	return commit
+}
+
+// toDataHashes converts hashes to [data.Hash] values.
+func toDataHashes(hashes []plumbing.Hash) []data.Hash {
+	dh := make([]data.Hash, len(hashes))
+	for i, h := range hashes {
+		dh[i] = data.Hash(h.String())
+	}
+	return dh
 }
 
 func readBlobContents(hash plumbing.Hash, repo *git.Repository) (string, error) {
@@ -430,43 +799,65 @@ func toCommitHash(rev string, repo *git.Repository) (plumbing.Hash, error) {
 	return *hash, nil
 }
 
-func toFilePatches(dPatches []diff.FilePatch) []data.FilePatch {
-	patches := make([]data.FilePatch, len(dPatches))
-	for i, dp := range dPatches {
-		chunks := dp.Chunks()
+func toFilePatches(fps []filePatch, context, maxLines int) []data.FilePatch {
+	patches := make([]data.FilePatch, len(fps))
+	for i, fp := range fps {
+		chunks := fp.Chunks()
 		p := data.FilePatch{
-			IsBinary: dp.IsBinary(),
-			Chunks:   make([]data.Chunk, len(chunks)),
+			IsBinary:   fp.IsBinary(),
+			Change:     fp.change,
+			Similarity: fp.similarity,
+			Chunks:     make([]data.Chunk, 0, len(chunks)),
+			Context:    context,
+			Truncated:  fp.truncated,
 		}
-		from, to := dp.Files()
-		switch {
-		case from == nil:
-			p.File = to.Path() + " (created)"
-		case to == nil:
-			p.File = from.Path() + " (deleted)"
-		case from.Path() == to.Path():
-			p.File = from.Path()
-		default:
-			p.File = from.Path() + " --> " + to.Path()
+		from, to := fp.Files()
+		if from != nil {
+			p.OldPath = from.Path()
 		}
-		for j, pc := range dp.Chunks() {
+		if to != nil {
+			p.NewPath = to.Path()
+		}
+		remaining := maxLines
+		for _, pc := range chunks {
+			if remaining <= 0 {
+				p.Truncated = true
+				break
+			}
 			c := data.Chunk{
-				Content: pc.Content(),
-				Type:    data.Operation(pc.Type()),
+				Type: data.Operation(pc.Type()),
+			}
+			var n int
+			c.Content, n = truncateLines(pc.Content(), remaining)
+			remaining -= n
+			p.Chunks = append(p.Chunks, c)
+			if len(c.Content) < len(pc.Content()) {
+				p.Truncated = true
+				break
 			}
-			p.Chunks[j] = c
 		}
 		patches[i] = p
 	}
 	return patches
 }
 
+// orDefault returns v if it is non-zero, otherwise d.
+func orDefault(v, d int) int {
+	if v == 0 {
+		return d
+	}
+	return v
+}
+
 func toDataRepo(repo *repo.Repo) data.Repo {
 	return data.Repo{
-		Slug:         repo.Slug,
-		Owner:        repo.Owner,
-		Description:  repo.Description,
-		LastModified: repo.LastModified,
+		Slug:               repo.Slug,
+		Owner:              repo.Owner,
+		Description:        repo.Description,
+		Category:           repo.Category,
+		Options:            repo.Options,
+		LastModified:       repo.LastModified,
+		LastModifiedSource: repo.LastModifiedSource,
 	}
 }
 
@@ -477,25 +868,3 @@ func toBlobLines(cLines []string) []data.BlobLine {
 	}
 	return lines
 }
-
-func renderMarkdown(m string) (template.HTML, error) {
-	outBuf := new(bytes.Buffer)
-	markdown := goldmark.New(
-		goldmark.WithExtensions(
-			extension.NewLinkify(
-				extension.WithLinkifyAllowedProtocols([][]byte{
-					[]byte("http:"),
-					[]byte("https:"),
-					[]byte("mailto:"),
-				}),
-				extension.WithLinkifyURLRegexp(
-					xurls.Strict(),
-				),
-			),
-		),
-	)
-	if err := markdown.Convert([]byte(m), outBuf); err != nil {
-		return "", fmt.Errorf("error rendering markdown: %w", err)
-	}
-	return template.HTML(outBuf.String()), nil
-}
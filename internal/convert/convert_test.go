@@ -0,0 +1,377 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"djmo.ch/dgit/config"
+	"djmo.ch/dgit/data"
+	"djmo.ch/dgit/internal/repo"
+	"djmo.ch/dgit/internal/request"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestToLogDataPages(t *testing.T) {
+	tr := newTestRepo(t)
+	root := tr.commit("root", nil)
+	a := tr.commit("a", nil, root)
+	x := tr.commit("x", nil, root)
+	b := tr.commit("b", nil, a)
+	y := tr.commit("y", nil, x)
+	tr.commit("merge", nil, b, y)
+	r := tr.r
+
+	cfg := config.Config{LogPageSize: 2}
+	for _, order := range []string{"", "topo"} {
+		var (
+			req  = &request.Request{Revision: "HEAD", Order: order}
+			seen = make(map[string]bool)
+		)
+		for page := 1; ; page += 1 {
+			req.Page = page
+			l, err := ToLogData(&repo.Repo{R: r}, req, cfg)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			if page > 1 && !l.HasPrev() {
+				t.Fatal("expected previous page link on page", page)
+			}
+			for _, c := range l.Commits {
+				if seen[string(c.Hash)] {
+					t.Fatalf("order %q: commit %s repeated on page %d", order, c.Hash, page)
+				}
+				seen[string(c.Hash)] = true
+			}
+			if !l.HasNext() {
+				break
+			}
+			req.From = l.FromHash
+		}
+		if len(seen) != 6 {
+			t.Fatalf("order %q: expected 6 commits, but got %d", order, len(seen))
+		}
+		for _, page := range []int{4, math.MaxInt / 2, math.MaxInt} {
+			req.Page = page
+			if _, err := ToLogData(&repo.Repo{R: r}, req, cfg); !errors.Is(err, ErrPageNotFound) {
+				t.Fatalf("order %q: expected page %d not found, but got %v", order, page, err)
+			}
+		}
+	}
+}
+
+func TestToRefsData(t *testing.T) {
+	tr := newTestRepo(t)
+	r := tr.r
+	root := tr.commit("root", nil)
+	topic := tr.commit("topic", nil, root)
+	if err := r.Storer.SetReference(plumbing.NewHashReference("refs/heads/topic", topic)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.CreateTag("v1", root, nil); err != nil {
+		t.Fatal(err)
+	}
+	master := tr.commit("main 1", nil, root)
+	tr.commit("main 2", nil, master)
+	if _, err := r.CreateTag("v2", topic, &git.CreateTagOptions{Tagger: tr.sig(), Message: "v2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &request.Request{}
+	refs, err := ToRefsData(&repo.Repo{R: r}, req, config.Config{})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if refs.Head != "master" || refs.BranchCount != 2 || refs.TagCount != 2 {
+		t.Fatal("expected head master, 2 branches and 2 tags, but got", refs.Head,
+			refs.BranchCount, refs.TagCount)
+	}
+	if b := refs.Branches[0]; b.Name != "master" || !b.IsHead || b.Commit.Subject() != "main 2" {
+		t.Fatalf("expected master first, at main 2, but got %+v", b)
+	}
+	if b := refs.Branches[1]; b.Name != "topic" || b.IsHead || b.Ahead != 1 || b.Behind != 2 {
+		t.Fatalf("expected topic 1 ahead and 2 behind, but got %+v", b)
+	}
+	if tag := refs.Tags[0]; tag.Name != "v2" || !tag.Annotated || tag.Commit.Subject() != "topic" {
+		t.Fatalf("expected annotated v2 first, at topic, but got %+v", tag)
+	}
+	if tag := refs.Tags[1]; tag.Name != "v1" || tag.Annotated || tag.Commit.Subject() != "root" {
+		t.Fatalf("expected lightweight v1, at root, but got %+v", tag)
+	}
+
+	cfg := config.Config{RefsPageSize: 1}
+	req = &request.Request{Query: "T", Kind: "branches", Page: 1}
+	if refs, err = ToRefsData(&repo.Repo{R: r}, req, cfg); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if len(refs.Branches) != 1 || refs.Branches[0].Name != "master" || len(refs.Tags) != 0 || !refs.HasNext() {
+		t.Fatalf("expected first of several branches, but got %+v", refs)
+	}
+	req.Query = "top"
+	if refs, err = ToRefsData(&repo.Repo{R: r}, req, cfg); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if refs.BranchCount != 1 || refs.Branches[0].Name != "topic" || refs.HasNext() {
+		t.Fatalf("expected only topic, but got %+v", refs)
+	}
+	req.Page = 2
+	if _, err = ToRefsData(&repo.Repo{R: r}, req, cfg); !errors.Is(err, ErrPageNotFound) {
+		t.Fatal("expected page not found, but got", err)
+	}
+}
+
+func TestToCompareData(t *testing.T) {
+	tr := newTestRepo(t)
+	base := tr.commit("base", map[string]string{"file": "base\n"})
+	upstream := tr.commit("upstream", map[string]string{"file": "upstream\n"})
+	tr.commit("topic 1", map[string]string{"file": "topic 1\n"}, base)
+	tr.commit("topic 2", map[string]string{"file": "topic 2\n"})
+	topic := tr.commit("topic 3", map[string]string{"file": "topic 3\n"})
+
+	req := &request.Request{DiffFrom: upstream.String(), DiffTo: topic.String(), MergeBase: true, Context: -1}
+	c, err := ToCompareData(&repo.Repo{R: tr.r}, req, config.Config{LogPageSize: 2})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if c.Ahead != 3 || c.Behind != 1 || c.MoreCommits() != 1 {
+		t.Fatal("expected 3 ahead, 1 behind and 1 more commit, but got", c.Ahead, c.Behind, c.MoreCommits())
+	}
+	if len(c.Commits) != 2 || c.Commits[0].Hash != data.Hash(topic.String()) {
+		t.Fatalf("expected 2 commits from topic 3, but got %+v", c.Commits)
+	}
+	if c.MergeBase != data.Hash(base.String()) || len(c.FilePatches) != 1 {
+		t.Fatal("expected a diff of one file from the merge base, but got", c.MergeBase, len(c.FilePatches))
+	}
+}
+
+func TestToCommitDataMerge(t *testing.T) {
+	tr := newTestRepo(t)
+	base := tr.commit("base", map[string]string{"file": "a\nx\nb\n", "other": "o\n"})
+	ours := tr.commit("ours", map[string]string{"file": "a\nours\nb\n", "other": "changed\n"}, base)
+	theirs := tr.commit("theirs", map[string]string{"file": "a\ntheirs\nb\n", "other": "o\n"}, base)
+	merge := tr.commit("merge", map[string]string{"file": "a\nresolved\nb\n", "other": "changed\n"}, ours, theirs)
+
+	req := &request.Request{Revision: merge.String(), Context: -1}
+	c, err := ToCommitData(&repo.Repo{R: tr.r}, req, config.Config{})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if !c.IsCombined() || c.FilePatches != nil || len(c.Combined) != 1 {
+		t.Fatalf("expected only a combined diff of one file, but got %d file patches and %d combined",
+			len(c.FilePatches), len(c.Combined))
+	}
+	if exp := " file | 3 +--\n"; c.Diffstat != exp {
+		t.Fatalf("diffstat: exp=%q, act=%q", exp, c.Diffstat)
+	}
+
+	req.Parent = 2
+	if c, err = ToCommitData(&repo.Repo{R: tr.r}, req, config.Config{}); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if c.IsCombined() || len(c.FilePatches) != 2 {
+		t.Fatalf("expected a diff of two files against parent 2, but got %d", len(c.FilePatches))
+	}
+
+	req.Parent = 3
+	if _, err = ToCommitData(&repo.Repo{R: tr.r}, req, config.Config{}); !errors.Is(err, ErrParentNotFound) {
+		t.Fatal("expected parent not found, but got", err)
+	}
+}
+
+func TestCombinedPatchesLimits(t *testing.T) {
+	tr := newTestRepo(t)
+	base := tr.commit("base", map[string]string{"a": "a\nx\nb\n", "b": "x\n"})
+	ours := tr.commit("ours", map[string]string{"a": "a\nours\nb\n", "b": "ours\n"}, base)
+	theirs := tr.commit("theirs", map[string]string{"a": "a\ntheirs\nb\n", "b": "theirs\n"}, base)
+	merge := tr.commit("merge", map[string]string{"a": "a\nresolved\nb\n", "b": "resolved\n"}, ours, theirs)
+	c, err := tr.r.CommitObject(merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &request.Request{Context: -1}
+
+	cps, truncated, err := combinedPatches(c, tr.r, req, config.Config{MaxDiffFiles: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !truncated || len(cps) != 1 || cps[0].Path != "a" {
+		t.Fatalf("expected only a, truncated, but got %d patches (truncated %t)", len(cps), truncated)
+	}
+
+	cps, truncated, err = combinedPatches(c, tr.r, req, config.Config{MaxDiffLines: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if truncated || len(cps) != 2 {
+		t.Fatalf("expected 2 patches, but got %d (truncated %t)", len(cps), truncated)
+	}
+	if !cps[0].Truncated || cps[0].Lines != nil {
+		t.Errorf("expected a to be truncated without lines, but got %+v", cps[0])
+	}
+	if len(cps[1].Lines) != 2 {
+		t.Errorf("expected b to be diffed and cut to 2 lines, but got %+v", cps[1])
+	}
+}
+
+func TestCombinedPatchesDeleted(t *testing.T) {
+	tr := newTestRepo(t)
+	base := tr.commit("base", map[string]string{"file": "a\nx\nb\n", "gone": "g\n"})
+	ours := tr.commit("ours", map[string]string{"file": "a\nours\nb\n"}, base)
+	theirs := tr.commit("theirs", map[string]string{"file": "a\ntheirs\nb\n"}, base)
+	if err := os.Remove(filepath.Join(tr.dir, "gone")); err != nil {
+		t.Fatal(err)
+	}
+	merge := tr.commit("merge", map[string]string{"file": "a\nresolved\nb\n"}, ours, theirs)
+	c, err := tr.r.CommitObject(merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cps, _, err := combinedPatches(c, tr.r, &request.Request{Context: -1}, config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cps) != 2 || cps[1].Path != "gone" {
+		t.Fatalf("expected patches of file and gone, but got %+v", cps)
+	}
+	exp := []data.CombinedLine{{Markers: "--", Content: "g"}}
+	if lines := cps[1].Hunks(); len(lines) != 1 || lines[0] != exp[0] {
+		t.Errorf("expected %+v, but got %+v", exp, lines)
+	}
+}
+
+func TestLogFilter(t *testing.T) {
+	f := toLogFilter(&request.Request{
+		Since:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Until:  time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC),
+		Author: "Alice",
+	}, nil)
+	commit := func(name, email string, when time.Time) *object.Commit {
+		sig := object.Signature{Name: name, Email: email, When: when}
+		return &object.Commit{Author: sig, Committer: sig}
+	}
+	for _, tc := range []struct {
+		c   *object.Commit
+		exp bool
+	}{
+		{commit("Alice", "a@example.com", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)), true},
+		{commit("Bob", "alice@example.com", time.Date(2024, 3, 31, 23, 59, 0, 0, time.UTC)), true},
+		{commit("Alice", "a@example.com", time.Date(2023, 12, 31, 23, 59, 0, 0, time.UTC)), false},
+		{commit("Alice", "a@example.com", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)), false},
+		{commit("Bob", "b@example.com", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)), false},
+	} {
+		if act := f.match(tc.c); act != tc.exp {
+			t.Errorf("%s <%s> at %s: exp=%t, act=%t", tc.c.Author.Name, tc.c.Author.Email,
+				tc.c.Committer.When, tc.exp, act)
+		}
+	}
+}
+
+func TestToDataCommit(t *testing.T) {
+	var (
+		zone   = time.FixedZone("", -5*60*60)
+		author = object.Signature{Name: "Alice", Email: "alice@example.com",
+			When: time.Date(2024, 1, 1, 9, 0, 0, 0, zone)}
+		committer = object.Signature{Name: "Bob", Email: "bob@example.com",
+			When: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)}
+		avatars = func(email string) string { return "/avatars/" + email }
+	)
+	c := toDataCommit(&object.Commit{Author: author, Committer: committer}, identities{avatars: avatars}, nil)
+	if c.AuthorEmail != author.Email || c.CommitterEmail != committer.Email {
+		t.Fatal("unexpected emails", c.AuthorEmail, c.CommitterEmail)
+	}
+	if _, offset := c.AuthorTime.Zone(); offset != -5*60*60 || !c.Time.Equal(committer.When) {
+		t.Fatal("unexpected times", c.AuthorTime, c.Time)
+	}
+	if c.AuthorAvatar != "/avatars/alice@example.com" || c.CommitterAvatar != "/avatars/bob@example.com" {
+		t.Fatal("unexpected avatars", c.AuthorAvatar, c.CommitterAvatar)
+	}
+	if c.IsCommittedByAuthor() {
+		t.Fatal("expected commit applied by another committer")
+	}
+	if c = toDataCommit(&object.Commit{Author: author, Committer: author}, identities{}, nil); c.AuthorAvatar != "" {
+		t.Fatal("expected no avatar without a provider, but got", c.AuthorAvatar)
+	}
+}
+
+func TestLoadMailmap(t *testing.T) {
+	tr := newTestRepo(t)
+	r := tr.r
+	mm, err := loadMailmap(r)
+	if err != nil {
+		t.Fatal("unexpected error for empty repository", err)
+	}
+	if name, _ := mm.Map("alice", "alice@laptop.local"); name != "alice" {
+		t.Fatal("expected empty mailmap, but got", name)
+	}
+
+	tr.commit("Add mailmap", map[string]string{
+		".mailmap": "Alice Doe <alice@example.com> <alice@laptop.local>\n",
+	})
+	contents := []byte("Alice D. <alice@example.com> <alice@laptop.local>\n")
+	err = os.WriteFile(filepath.Join(tr.dir, ".git", "mailmap"), contents, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm, err = loadMailmap(r)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if name, email := mm.Map("alice", "alice@laptop.local"); name != "Alice Doe" || email != "alice@example.com" {
+		t.Fatal("expected identity from .mailmap, but got", name, email)
+	}
+
+	gc, err := r.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gc.Raw.Section("mailmap").SetOption("file", "mailmap")
+	if err = r.SetConfig(gc); err != nil {
+		t.Fatal(err)
+	}
+	if mm, err = loadMailmap(r); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if name, _ := mm.Map("alice", "alice@laptop.local"); name != "Alice D." {
+		t.Fatal("expected mailmap.file to take precedence, but got", name)
+	}
+	if cached, _ := loadMailmap(r); cached != mm {
+		t.Fatal("expected the unchanged mailmap to be cached")
+	}
+
+	outside := filepath.Join(t.TempDir(), "mailmap")
+	if err = os.WriteFile(outside, contents, 0666); err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(filepath.Join(tr.dir, ".git"), outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{outside, rel} {
+		gc.Raw.Section("mailmap").SetOption("file", file)
+		if err = r.SetConfig(gc); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = loadMailmap(r); err == nil {
+			t.Fatalf("expected error reading %s outside the repository", file)
+		}
+	}
+
+	gc.Raw.Section("mailmap").SetOption("file", "objects")
+	if err = r.SetConfig(gc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = loadMailmap(r); err == nil {
+		t.Fatal("expected error reading a directory as mailmap.file")
+	}
+	if ids := newIdentities(&repo.Repo{R: r}, config.Config{}); ids.mailmap != nil {
+		t.Fatal("expected no mailmap after an error, but got", ids.mailmap)
+	}
+}
@@ -0,0 +1,631 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"djmo.ch/dgit/config"
+	"djmo.ch/dgit/data"
+	"djmo.ch/dgit/internal/request"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// toDiffOptions returns the diff options requested by req.
+func toDiffOptions(req *request.Request) data.DiffOptions {
+	return data.DiffOptions{
+		View:             req.View,
+		IgnoreWhitespace: req.IgnoreWhitespace,
+		Context:          req.Context,
+		Parent:           req.Parent,
+	}
+}
+
+// diffContext returns the number of context lines presented on either
+// side of a change, as requested by req or configured by cfg.
+func diffContext(req *request.Request, cfg config.Config) int {
+	if req.Context >= 0 {
+		return req.Context
+	}
+	return max(orDefault(cfg.DiffContext, config.DefaultDiffContext), 0)
+}
+
+// limit returns v if it is positive, d if v is zero, and otherwise
+// the largest int, such that a negative limit is no limit.
+func limit(v, d int) int {
+	switch {
+	case v > 0:
+		return v
+	case v == 0:
+		return d
+	default:
+		return math.MaxInt
+	}
+}
+
+// sizeLimit is like limit, for sizes in bytes.
+func sizeLimit(v, d int64) int64 {
+	switch {
+	case v > 0:
+		return v
+	case v == 0:
+		return d
+	default:
+		return math.MaxInt64
+	}
+}
+
+// diffTrees returns the file patches from tree a to tree b, either of
+// which may be nil, and their diffstat. Files beyond the limit set by
+// cfg are omitted, in which case truncated is true and the diffstat
+// ends with a count of them.
+func diffTrees(a, b *object.Tree, r *git.Repository, req *request.Request, cfg config.Config) (patches []data.FilePatch, stat string, truncated bool, err error) {
+	maxFiles := limit(cfg.MaxDiffFiles, config.DefaultMaxDiffFiles)
+	fcs, err := treeChanges(a, b, r, cfg, maxFiles)
+	if err != nil {
+		return nil, "", false, err
+	}
+	var omitted int
+	if len(fcs) > maxFiles {
+		fcs, omitted, truncated = fcs[:maxFiles], len(fcs)-maxFiles, true
+	}
+	fps, err := patchChanges(fcs, r, sizeLimit(cfg.MaxBlobSize, config.DefaultMaxBlobSize))
+	if err != nil {
+		return nil, "", false, err
+	}
+	if req.IgnoreWhitespace {
+		if fps, err = ignoreWhitespace(fps, r); err != nil {
+			return nil, "", false, fmt.Errorf("error ignoring whitespace: %w", err)
+		}
+	}
+	patches = toFilePatches(fps, diffContext(req, cfg),
+		limit(cfg.MaxDiffLines, config.DefaultMaxDiffLines))
+	stat = diffstat(fps).String()
+	if omitted > 0 {
+		stat += fmt.Sprintf(" ... %d more %s\n", omitted, plural(omitted, "file", "files"))
+	}
+	return patches, stat, truncated, nil
+}
+
+// renameLimit is the largest number of added files, and of deleted or
+// modified files, compared by content when detecting renames and
+// copies.
+const renameLimit = 1000
+
+// copyPairLimit is the largest number of pairs of added and modified
+// files compared by content when detecting copies.
+const copyPairLimit = renameLimit
+
+// similarityMaxSize is the size, in bytes, of the largest blob
+// compared by content when detecting renames and copies. Larger blobs
+// are only similar to identical blobs.
+const similarityMaxSize = 256 << 10
+
+// blobCacheSize is the total size, in bytes, of the blobs whose lines
+// are kept by a blobCache.
+const blobCacheSize = 32 << 20
+
+// fileChange is a change to a single file and its detected type.
+type fileChange struct {
+	*object.Change
+	change     data.ChangeType
+	similarity int
+}
+
+// treeChanges returns the changes from tree a to tree b, either of
+// which may be nil, detecting renames and copies as configured by cfg.
+// If there are more than maxFiles changes, only exact renames and
+// copies are detected, as most of the changes will not be shown.
+func treeChanges(a, b *object.Tree, r *git.Repository, cfg config.Config, maxFiles int) ([]fileChange, error) {
+	threshold := orDefault(cfg.RenameThreshold, config.DefaultRenameThreshold)
+	changes, err := object.DiffTreeWithOptions(context.Background(), a, b, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error comparing trees: %w", err)
+	}
+	onlyExact := len(changes) > maxFiles
+	if threshold >= 0 {
+		changes, err = object.DetectRenames(changes, &object.DiffTreeOptions{
+			DetectRenames:    true,
+			RenameScore:      uint(threshold),
+			RenameLimit:      renameLimit,
+			OnlyExactRenames: onlyExact,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error detecting renames: %w", err)
+		}
+	}
+	var (
+		fcs   = make([]fileChange, len(changes))
+		blobs = newBlobCache(r, min(sizeLimit(cfg.MaxBlobSize, config.DefaultMaxBlobSize), similarityMaxSize))
+	)
+	for i, c := range changes {
+		fcs[i].Change = c
+		switch {
+		case c.From.Name == "":
+			fcs[i].change = data.ChangeAdded
+		case c.To.Name == "":
+			fcs[i].change = data.ChangeDeleted
+		case c.From.Name != c.To.Name:
+			fcs[i].change = data.ChangeRenamed
+			score, err := blobs.similarity(c.From, c.To)
+			if err != nil {
+				return nil, err
+			}
+			fcs[i].similarity = score
+		}
+	}
+	if threshold >= 0 {
+		if err := detectCopies(fcs, threshold, blobs, onlyExact); err != nil {
+			return nil, err
+		}
+	}
+	return fcs, nil
+}
+
+// detectCopies marks each added file in fcs whose similarity to a
+// modified file is at least threshold as a copy of the most similar
+// one. If onlyExact is true, or there are more pairs of files to
+// compare than copyPairLimit, only identical files are detected as
+// copies.
+func detectCopies(fcs []fileChange, threshold int, blobs *blobCache, onlyExact bool) error {
+	var (
+		sources, adds []int
+		// identical maps blob hashes to the first source with them
+		identical = make(map[plumbing.Hash]int)
+	)
+	for i, fc := range fcs {
+		switch {
+		case fc.change == data.ChangeModified && fc.From.TreeEntry.Mode.IsFile():
+			sources = append(sources, i)
+			if _, ok := identical[fc.From.TreeEntry.Hash]; !ok {
+				identical[fc.From.TreeEntry.Hash] = i
+			}
+		case fc.change == data.ChangeAdded && fc.To.TreeEntry.Mode.IsFile():
+			adds = append(adds, i)
+		}
+	}
+	onlyExact = onlyExact || len(sources)*len(adds) > copyPairLimit
+	for _, i := range adds {
+		best, bestScore := -1, threshold
+		if j, ok := identical[fcs[i].To.TreeEntry.Hash]; ok {
+			best, bestScore = j, 100
+		} else if !onlyExact {
+			for _, j := range sources {
+				score, err := blobs.similarity(fcs[j].From, fcs[i].To)
+				if err != nil {
+					return err
+				}
+				if score > bestScore || (score == bestScore && best < 0) {
+					best, bestScore = j, score
+				}
+			}
+		}
+		if best < 0 {
+			continue
+		}
+		fcs[i] = fileChange{
+			Change: &object.Change{
+				From: fcs[best].From,
+				To:   fcs[i].To,
+			},
+			change:     data.ChangeCopied,
+			similarity: bestScore,
+		}
+	}
+	return nil
+}
+
+// blobCache reads blobs compared when detecting renames and copies,
+// and keeps their lines, up to blobCacheSize bytes of blobs.
+type blobCache struct {
+	r       *git.Repository
+	maxSize int64
+	lines   map[plumbing.Hash]lineSet
+	// size is the total size of the blobs in lines
+	size int
+}
+
+func newBlobCache(r *git.Repository, maxSize int64) *blobCache {
+	return &blobCache{
+		r:       r,
+		maxSize: maxSize,
+		lines:   make(map[plumbing.Hash]lineSet),
+	}
+}
+
+// similarity returns the similarity, as a percentage, of the files at
+// tree entries a and b. Files larger than c.maxSize are not read, and
+// are only similar to identical files.
+func (c *blobCache) similarity(a, b object.ChangeEntry) (int, error) {
+	if a.TreeEntry.Hash == b.TreeEntry.Hash {
+		return 100, nil
+	}
+	if !a.TreeEntry.Mode.IsFile() || !b.TreeEntry.Mode.IsFile() {
+		return 0, nil
+	}
+	var sets [2]lineSet
+	for i, e := range []object.ChangeEntry{a, b} {
+		set, ok := c.lines[e.TreeEntry.Hash]
+		if !ok {
+			if large, err := isLarge(e, c.r, c.maxSize); err != nil || large {
+				return 0, err
+			}
+			src, err := readBlobContents(e.TreeEntry.Hash, c.r)
+			if err != nil {
+				return 0, err
+			}
+			set = newLineSet(src)
+			if c.size+set.size <= blobCacheSize {
+				c.lines[e.TreeEntry.Hash] = set
+				c.size += set.size
+			}
+		}
+		sets[i] = set
+	}
+	return sets[0].similarity(sets[1]), nil
+}
+
+// isLarge returns true if the file at tree entry e is larger than
+// maxSize.
+func isLarge(e object.ChangeEntry, r *git.Repository, maxSize int64) (bool, error) {
+	if e.Name == "" || !e.TreeEntry.Mode.IsFile() {
+		return false, nil
+	}
+	size, err := r.Storer.EncodedObjectSize(e.TreeEntry.Hash)
+	if err != nil {
+		return false, fmt.Errorf("error getting size of %s: %w", e.Name, err)
+	}
+	return size > maxSize, nil
+}
+
+// similarity returns the similarity, as a percentage, of a and b: the
+// size of the lines they have in common relative to the size of the
+// larger of the two.
+func similarity(a, b string) int {
+	return newLineSet(a).similarity(newLineSet(b))
+}
+
+// lineSet is the multiset of the lines of a blob, and the blob's size.
+type lineSet struct {
+	lines map[string]int
+	size  int
+}
+
+func newLineSet(s string) lineSet {
+	set := lineSet{lines: make(map[string]int), size: len(s)}
+	for _, line := range splitLines(s) {
+		set.lines[line] += 1
+	}
+	return set
+}
+
+// similarity returns the similarity, as a percentage, of the blobs of
+// a and b, as for the package-level similarity.
+func (a lineSet) similarity(b lineSet) int {
+	if a.size == 0 && b.size == 0 {
+		return 100
+	}
+	common := 0
+	for line, n := range b.lines {
+		common += min(n, a.lines[line]) * len(line)
+	}
+	return common * 100 / max(a.size, b.size)
+}
+
+// filePatch is the patch of a single file and its detected change
+// type.
+type filePatch struct {
+	diff.FilePatch
+	change     data.ChangeType
+	similarity int
+	// True if either version of the file is too large to diff
+	truncated bool
+}
+
+// patchChanges returns the patches of fcs. Files larger than maxSize
+// are not read, and their patches are empty and truncated.
+func patchChanges(fcs []fileChange, r *git.Repository, maxSize int64) ([]filePatch, error) {
+	var (
+		fps     = make([]filePatch, len(fcs))
+		changes = make(object.Changes, 0, len(fcs))
+		patched = make([]int, 0, len(fcs))
+	)
+	for i, fc := range fcs {
+		fps[i] = filePatch{change: fc.change, similarity: fc.similarity}
+		for _, e := range []object.ChangeEntry{fc.From, fc.To} {
+			large, err := isLarge(e, r, maxSize)
+			if err != nil {
+				return nil, err
+			}
+			fps[i].truncated = fps[i].truncated || large
+		}
+		if fps[i].truncated {
+			fps[i].FilePatch = newLargePatch(fc.Change)
+			continue
+		}
+		changes = append(changes, fc.Change)
+		patched = append(patched, i)
+	}
+	patch, err := changes.Patch()
+	if err != nil {
+		return nil, fmt.Errorf("error generating patch: %w", err)
+	}
+	for i, fp := range patch.FilePatches() {
+		fps[patched[i]].FilePatch = fp
+	}
+	return fps, nil
+}
+
+// largePatch implements the [diff.FilePatch] interface for a change
+// too large to diff. It has no chunks.
+type largePatch struct {
+	from, to diff.File
+}
+
+func newLargePatch(c *object.Change) largePatch {
+	var p largePatch
+	if c.From.Name != "" {
+		p.from = entryFile{c.From}
+	}
+	if c.To.Name != "" {
+		p.to = entryFile{c.To}
+	}
+	return p
+}
+
+func (p largePatch) IsBinary() bool                { return false }
+func (p largePatch) Files() (diff.File, diff.File) { return p.from, p.to }
+func (p largePatch) Chunks() []diff.Chunk          { return nil }
+
+// entryFile implements the [diff.File] interface for a tree entry.
+type entryFile struct {
+	object.ChangeEntry
+}
+
+func (f entryFile) Hash() plumbing.Hash     { return f.TreeEntry.Hash }
+func (f entryFile) Mode() filemode.FileMode { return f.TreeEntry.Mode }
+func (f entryFile) Path() string            { return f.Name }
+
+// chunk implements the [diff.Chunk] interface.
+type chunk struct {
+	content string
+	op      diff.Operation
+}
+
+func (c chunk) Content() string      { return c.content }
+func (c chunk) Type() diff.Operation { return c.op }
+
+// chunksPatch wraps a [diff.FilePatch], replacing its chunks.
+type chunksPatch struct {
+	diff.FilePatch
+	chunks []diff.Chunk
+}
+
+func (fp chunksPatch) Chunks() []diff.Chunk { return fp.chunks }
+
+// ignoreWhitespace returns fps with each file's chunks recomputed such
+// that lines differing only in whitespace are considered equal. Files
+// left without changes are omitted.
+func ignoreWhitespace(fps []filePatch, r *git.Repository) ([]filePatch, error) {
+	patches := make([]filePatch, 0, len(fps))
+	for _, fp := range fps {
+		if fp.IsBinary() || len(fp.Chunks()) == 0 {
+			patches = append(patches, fp)
+			continue
+		}
+		var oldSrc, newSrc string
+		from, to := fp.Files()
+		if from != nil {
+			src, err := readBlobContents(from.Hash(), r)
+			if err != nil {
+				return nil, err
+			}
+			oldSrc = src
+		}
+		if to != nil {
+			src, err := readBlobContents(to.Hash(), r)
+			if err != nil {
+				return nil, err
+			}
+			newSrc = src
+		}
+		chunks := whitespaceChunks(oldSrc, newSrc)
+		if from != nil && to != nil && from.Path() == to.Path() &&
+			from.Mode() == to.Mode() && !hasChanges(chunks) {
+			continue
+		}
+		fp.FilePatch = chunksPatch{FilePatch: fp.FilePatch, chunks: chunks}
+		patches = append(patches, fp)
+	}
+	return patches, nil
+}
+
+// whitespaceChunks returns the chunks of a line diff between oldSrc
+// and newSrc that ignores whitespace. Equal lines are taken from
+// newSrc.
+func whitespaceChunks(oldSrc, newSrc string) []diff.Chunk {
+	var (
+		oldLines = splitLines(oldSrc)
+		newLines = splitLines(newSrc)
+		diffs    = lineDiffs(oldLines, newLines, whitespaceKey)
+
+		chunks []diff.Chunk
+		i, j   int
+	)
+	add := func(lines []string, op diff.Operation) {
+		content := strings.Join(lines, "")
+		if n := len(chunks); n > 0 && chunks[n-1].Type() == op {
+			chunks[n-1] = chunk{content: chunks[n-1].Content() + content, op: op}
+			return
+		}
+		chunks = append(chunks, chunk{content: content, op: op})
+	}
+	for _, d := range diffs {
+		n := utf8.RuneCountInString(d.Text)
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			add(newLines[j:j+n], diff.Equal)
+			i, j = i+n, j+n
+		case diffmatchpatch.DiffDelete:
+			add(oldLines[i:i+n], diff.Delete)
+			i += n
+		case diffmatchpatch.DiffInsert:
+			add(newLines[j:j+n], diff.Add)
+			j += n
+		}
+	}
+	return chunks
+}
+
+// lineDiffTimeout bounds the time spent computing a single line diff
+// in lineDiffs, past which the diff is valid but not minimal.
+const lineDiffTimeout = 50 * time.Millisecond
+
+// lineDiffs returns a line diff between oldLines and newLines, in
+// which lines are equal if their keys are equal. The text of each
+// returned diff holds one rune per line. If the lines have more keys
+// than there are runes to stand for them, every line is changed.
+func lineDiffs(oldLines, newLines []string, key func(string) string) []diffmatchpatch.Diff {
+	// Each line is mapped to a rune identifying its key, so that a
+	// diff of the runes is a line diff.
+	keys := make(map[string]rune)
+	toRunes := func(lines []string) ([]rune, bool) {
+		rs := make([]rune, len(lines))
+		for i, line := range lines {
+			k := key(line)
+			r, ok := keys[k]
+			if !ok {
+				if r, ok = lineRune(len(keys)); !ok {
+					return nil, false
+				}
+				keys[k] = r
+			}
+			rs[i] = r
+		}
+		return rs, true
+	}
+	oldRunes, okOld := toRunes(oldLines)
+	newRunes, okNew := toRunes(newLines)
+	if !okOld || !okNew {
+		return []diffmatchpatch.Diff{
+			{Type: diffmatchpatch.DiffDelete, Text: strings.Repeat("-", len(oldLines))},
+			{Type: diffmatchpatch.DiffInsert, Text: strings.Repeat("+", len(newLines))},
+		}
+	}
+	dmp := diffmatchpatch.New()
+	dmp.DiffTimeout = lineDiffTimeout
+	return dmp.DiffMainRunes(oldRunes, newRunes, false)
+}
+
+// lineRune returns the rune standing for the nth distinct line key in
+// lineDiffs, and false if there is none. Surrogates are skipped, as
+// they are not valid in strings, and so would all be converted to
+// U+FFFD, and compare equal, in the diff's cleanup.
+func lineRune(n int) (rune, bool) {
+	if n >= utf8.MaxRune {
+		return 0, false
+	}
+	r := rune(n)
+	if r >= surrogateMin {
+		r += surrogateMax - surrogateMin + 1
+	}
+	return r, r <= utf8.MaxRune
+}
+
+// The range of UTF-16 surrogate halves, which are not valid runes
+const (
+	surrogateMin = 0xD800
+	surrogateMax = 0xDFFF
+)
+
+// whitespaceKey returns line with all whitespace removed.
+func whitespaceKey(line string) string {
+	return strings.Join(strings.Fields(line), "")
+}
+
+// truncateLines returns the first n lines of s, or all of s if it has
+// no more than n lines, and the number of lines returned.
+func truncateLines(s string, n int) (string, int) {
+	i, count := 0, 0
+	for ; i < len(s); count += 1 {
+		if count == n {
+			return s[:i], count
+		}
+		next := strings.IndexByte(s[i:], '\n')
+		if next < 0 {
+			return s, count + 1
+		}
+		i += next + 1
+	}
+	return s, count
+}
+
+// splitLines splits s after each newline.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// hasChanges returns true if any of chunks is not Equal.
+func hasChanges(chunks []diff.Chunk) bool {
+	for _, c := range chunks {
+		if c.Type() != diff.Equal {
+			return true
+		}
+	}
+	return false
+}
+
+// diffstat returns the file stats of fps, computed from their chunks
+// in the same manner as [object.Patch.Stats].
+func diffstat(fps []filePatch) object.FileStats {
+	var stats object.FileStats
+	for _, fp := range fps {
+		if len(fp.Chunks()) == 0 {
+			continue
+		}
+		var fs object.FileStat
+		from, to := fp.Files()
+		switch {
+		case from == nil:
+			fs.Name = to.Path()
+		case to == nil:
+			fs.Name = from.Path()
+		case from.Path() != to.Path():
+			fs.Name = fmt.Sprintf("%s => %s", from.Path(), to.Path())
+		default:
+			fs.Name = from.Path()
+		}
+		for _, c := range fp.Chunks() {
+			s := c.Content()
+			if len(s) == 0 {
+				continue
+			}
+			n := strings.Count(s, "\n")
+			if s[len(s)-1] != '\n' {
+				n += 1
+			}
+			switch c.Type() {
+			case diff.Add:
+				fs.Addition += n
+			case diff.Delete:
+				fs.Deletion += n
+			}
+		}
+		stats = append(stats, fs)
+	}
+	return stats
+}
@@ -0,0 +1,283 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"djmo.ch/dgit/config"
+	"djmo.ch/dgit/data"
+	"djmo.ch/dgit/internal/request"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+func TestWhitespaceChunks(t *testing.T) {
+	var (
+		oldSrc = "a\n\tb c\nd\n"
+		newSrc = "a\n    b  c\nD\n"
+		exp    = []chunk{
+			{"a\n    b  c\n", diff.Equal},
+			{"d\n", diff.Delete},
+			{"D\n", diff.Add},
+		}
+	)
+	chunks := whitespaceChunks(oldSrc, newSrc)
+	if len(chunks) != len(exp) {
+		t.Fatalf("expected %d chunks, but got %d", len(exp), len(chunks))
+	}
+	for i, c := range chunks {
+		if c != exp[i] {
+			t.Errorf("chunk %d: expected %v, but got %v", i, exp[i], c)
+		}
+	}
+	if hasChanges(whitespaceChunks("a b\n", "a  b\n")) {
+		t.Error("expected no changes")
+	}
+}
+
+func TestLineDiffsManyLines(t *testing.T) {
+	// More distinct lines than there are runes below the surrogates,
+	// two of which, both past the surrogates, differ.
+	const n = 0xE000
+	var oldLines, newLines []string
+	for i := 0; i < n; i += 1 {
+		oldLines = append(oldLines, fmt.Sprintf("line %d\n", i))
+	}
+	newLines = append(newLines, oldLines...)
+	newLines[n-2] = "changed\n"
+	var changed int
+	for _, d := range lineDiffs(oldLines, newLines, func(s string) string { return s }) {
+		if d.Type != diffmatchpatch.DiffEqual {
+			changed += utf8.RuneCountInString(d.Text)
+		}
+	}
+	if changed != 2 {
+		t.Fatalf("expected 2 changed lines, but got %d", changed)
+	}
+	if r, ok := lineRune(surrogateMin); !ok || r != surrogateMax+1 {
+		t.Errorf("expected rune %U past the surrogates, but got %U", surrogateMax+1, r)
+	}
+	if _, ok := lineRune(utf8.MaxRune); ok {
+		t.Error("expected no rune past utf8.MaxRune")
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		exp  int
+	}{
+		{"", "", 100},
+		{"a\nb\n", "a\nb\n", 100},
+		{"a\nb\n", "a\nc\n", 50},
+		{"a\nb\n", "c\n", 0},
+	}
+	for _, test := range tests {
+		if act := similarity(test.a, test.b); act != test.exp {
+			t.Errorf("similarity(%q, %q): expected %d, but got %d", test.a, test.b, test.exp, act)
+		}
+	}
+}
+
+func TestTreeChanges(t *testing.T) {
+	var (
+		long   = strings.Repeat("a line of text\n", 10)
+		other  = strings.Repeat("another line\n", 10)
+		before = map[string]string{
+			"moved":    other,
+			"modified": long,
+			"deleted":  "gone\n",
+		}
+		after = map[string]string{
+			"renamed":  other + "more\n",
+			"modified": long + "changed\n",
+			"copied":   long,
+			"added":    "new\n",
+		}
+		exp = map[string]struct {
+			oldPath    string
+			change     data.ChangeType
+			similarity int
+		}{
+			"renamed":  {"moved", data.ChangeRenamed, 96},
+			"modified": {"modified", data.ChangeModified, 0},
+			"copied":   {"modified", data.ChangeCopied, 100},
+			"added":    {"", data.ChangeAdded, 0},
+			"deleted":  {"deleted", data.ChangeDeleted, 0},
+		}
+	)
+	tr := newTestRepo(t)
+	r := tr.r
+	a := tr.tree(tr.commit("before", before))
+	for name := range before {
+		if err := os.Remove(filepath.Join(tr.dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := tr.tree(tr.commit("after", after))
+
+	fcs, err := treeChanges(a, b, r, config.Config{}, math.MaxInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fcs) != len(exp) {
+		t.Fatalf("expected %d changes, but got %d", len(exp), len(fcs))
+	}
+	for _, fc := range fcs {
+		name := fc.To.Name
+		if name == "" {
+			name = fc.From.Name
+		}
+		e, ok := exp[name]
+		switch {
+		case !ok:
+			t.Errorf("unexpected change to %s", name)
+		case fc.From.Name != e.oldPath || fc.change != e.change || fc.similarity != e.similarity:
+			t.Errorf("%s: expected %s %s (%d%%), but got %s %s (%d%%)", name,
+				e.change, e.oldPath, e.similarity, fc.change, fc.From.Name, fc.similarity)
+		}
+	}
+
+	fcs, err = treeChanges(a, b, r, config.Config{RenameThreshold: -1}, math.MaxInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fc := range fcs {
+		if fc.change == data.ChangeRenamed || fc.change == data.ChangeCopied {
+			t.Errorf("unexpected %s %s with detection disabled", fc.change, fc.To.Name)
+		}
+	}
+
+	// With more changes than are shown, only the exact copy is
+	// detected.
+	fcs, err = treeChanges(a, b, r, config.Config{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fc := range fcs {
+		switch {
+		case fc.To.Name == "copied" && fc.change != data.ChangeCopied:
+			t.Errorf("expected copied to be an exact copy, but got %s", fc.change)
+		case fc.change == data.ChangeRenamed:
+			t.Errorf("unexpected inexact rename of %s to %s", fc.From.Name, fc.To.Name)
+		}
+	}
+
+	_, stat, truncated, err := diffTrees(a, b, r, &request.Request{Context: -1}, config.Config{MaxDiffFiles: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !truncated || !strings.HasSuffix(stat, " ... 4 more files\n") {
+		t.Errorf("expected truncated diffstat noting 4 more files, but got %q", stat)
+	}
+}
+
+func TestDetectCopiesPairLimit(t *testing.T) {
+	var (
+		before = make(map[string]string)
+		after  = make(map[string]string)
+		long   = strings.Repeat("a line of text\n", 10)
+	)
+	for i := range 40 {
+		name := fmt.Sprintf("modified%d", i)
+		before[name] = fmt.Sprintf("%d\n%s", i, long)
+		after[name] = before[name] + "changed\n"
+		after[fmt.Sprintf("added%d", i)] = fmt.Sprintf("new %d\n", i)
+	}
+	after["exact"] = before["modified0"]
+	after["inexact"] = before["modified1"] + "more\n"
+	tr := newTestRepo(t)
+	a := tr.tree(tr.commit("before", before))
+	b := tr.tree(tr.commit("after", after))
+
+	// 42 adds and 40 modified files are more pairs than are compared
+	fcs, err := treeChanges(a, b, tr.r, config.Config{}, math.MaxInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fc := range fcs {
+		switch fc.To.Name {
+		case "exact":
+			if fc.change != data.ChangeCopied || fc.From.Name != "modified0" {
+				t.Errorf("expected exact copy of modified0, but got %s %s", fc.change, fc.From.Name)
+			}
+		case "inexact":
+			if fc.change != data.ChangeAdded {
+				t.Errorf("expected inexact copy to be added, but got %s %s", fc.change, fc.From.Name)
+			}
+		}
+	}
+}
+
+func TestCombineLines(t *testing.T) {
+	var (
+		src        = "a\nresolved\nb\n"
+		parentSrcs = []string{"a\nours\nb\n", "a\ntheirs\nb\n"}
+		exp        = []data.CombinedLine{
+			{Markers: "  ", Line: "1", Content: "a"},
+			{Markers: "- ", Content: "ours"},
+			{Markers: " -", Content: "theirs"},
+			{Markers: "++", Line: "2", Content: "resolved"},
+			{Markers: "  ", Line: "3", Content: "b"},
+		}
+	)
+	lines := combineLines(src, parentSrcs, func(line string) string { return line })
+	if len(lines) != len(exp) {
+		t.Fatalf("expected %d lines, but got %d", len(exp), len(lines))
+	}
+	for i, line := range lines {
+		if line != exp[i] {
+			t.Errorf("line %d: expected %+v, but got %+v", i, exp[i], line)
+		}
+	}
+}
+
+func TestTruncateLines(t *testing.T) {
+	tests := []struct {
+		s     string
+		n     int
+		exp   string
+		count int
+	}{
+		{"a\nb\nc\n", 2, "a\nb\n", 2},
+		{"a\nb\nc\n", 3, "a\nb\nc\n", 3},
+		{"a\nb\nc", 5, "a\nb\nc", 3},
+		{"a\nb\n", 0, "", 0},
+	}
+	for _, test := range tests {
+		act, count := truncateLines(test.s, test.n)
+		if act != test.exp || count != test.count {
+			t.Errorf("truncateLines(%q, %d): expected %q, %d, but got %q, %d",
+				test.s, test.n, test.exp, test.count, act, count)
+		}
+	}
+}
+
+func TestToFilePatchesTruncated(t *testing.T) {
+	fps := []filePatch{{
+		FilePatch: chunksPatch{
+			FilePatch: largePatch{},
+			chunks: []diff.Chunk{
+				chunk{"a\nb\n", diff.Equal},
+				chunk{"c\nd\n", diff.Add},
+			},
+		},
+	}}
+	patches := toFilePatches(fps, 3, 3)
+	if !patches[0].Truncated {
+		t.Error("expected truncated file patch")
+	}
+	if n := len(patches[0].Chunks); n != 2 || patches[0].Chunks[1].Content != "c\n" {
+		t.Errorf("expected chunks truncated to 3 lines, but got %+v", patches[0].Chunks)
+	}
+	if patches := toFilePatches(fps, 3, 4); patches[0].Truncated {
+		t.Error("unexpected truncated file patch")
+	}
+}
@@ -0,0 +1,238 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"fmt"
+	"sync"
+
+	"djmo.ch/dgit/data"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// lane is a line of history in a commit graph, leading to the commit
+// with the given hash.
+type lane struct {
+	hash  data.Hash
+	color int
+}
+
+// setGraph sets the Graph of each of commits, which are ordered with
+// every commit following its children in the slice. Each row is as
+// wide as the lanes it draws; see [alignGraph].
+func setGraph(commits []data.Commit) {
+	var (
+		lanes     []lane
+		nextColor int
+		children  = make(map[data.Hash]int)
+	)
+	newLane := func(hash data.Hash) lane {
+		l := lane{hash: hash, color: nextColor % data.GraphColors}
+		nextColor += 1
+		return l
+	}
+	for i := range commits {
+		c := &commits[i]
+		col, ends := -1, 0
+		for j, l := range lanes {
+			if l.hash == c.Hash {
+				if col < 0 {
+					col = j
+				}
+				ends += 1
+			}
+		}
+		if col < 0 {
+			lanes = append(lanes, newLane(c.Hash))
+			col = len(lanes) - 1
+		}
+		row := &data.GraphRow{Column: col, Color: lanes[col].color, Fork: children[c.Hash] > 1}
+		for _, p := range c.ParentHashes {
+			children[p] += 1
+		}
+
+		// Secondary parents already expected by another lane join
+		// that lane rather than starting their own.
+		joins := make(map[int]int)
+		for k, p := range c.ParentHashes[min(1, len(c.ParentHashes)):] {
+			for j, l := range lanes {
+				if l.hash == p && j != col {
+					joins[k+1] = j
+					break
+				}
+			}
+		}
+
+		var (
+			next      = make([]lane, 0, len(lanes)+len(c.ParentHashes))
+			pos       = make([]int, len(lanes))
+			parentPos = make([]int, len(c.ParentHashes))
+		)
+		for j, l := range lanes {
+			pos[j] = -1
+			switch {
+			case j == col:
+				for k, p := range c.ParentHashes {
+					if _, ok := joins[k]; ok {
+						continue
+					}
+					parentPos[k] = len(next)
+					if k == 0 {
+						next = append(next, lane{hash: p, color: l.color})
+					} else {
+						next = append(next, newLane(p))
+					}
+				}
+			case l.hash != c.Hash:
+				pos[j] = len(next)
+				next = append(next, l)
+			}
+		}
+		for k, j := range joins {
+			parentPos[k] = pos[j]
+		}
+
+		for j, l := range lanes {
+			switch {
+			case l.hash == c.Hash && ends > 0:
+				row.Edges = append(row.Edges, data.GraphEdge{
+					From: j, To: col, Span: data.SpanIn, Color: l.color,
+				})
+			case l.hash != c.Hash:
+				row.Edges = append(row.Edges, data.GraphEdge{
+					From: j, To: pos[j], Span: data.SpanThrough, Color: l.color,
+				})
+			}
+		}
+		for _, k := range parentPos {
+			row.Edges = append(row.Edges, data.GraphEdge{
+				From: col, To: k, Span: data.SpanOut, Color: next[k].color,
+			})
+		}
+
+		row.Columns = max(len(lanes), len(next))
+		c.Graph = row
+		lanes = next
+	}
+}
+
+// alignGraph widens the Graph of each of commits to the number of
+// columns of the widest, so that the rows line up when drawn.
+func alignGraph(commits []data.Commit) {
+	var columns int
+	for _, c := range commits {
+		columns = max(columns, c.Graph.Columns)
+	}
+	for _, c := range commits {
+		c.Graph.Columns = columns
+	}
+}
+
+// topoLog returns at most n commits reachable from the commit with the
+// given hash and selected by match, in topological order: no commit
+// precedes any of its children, and each line of history is kept
+// together, as by git log --topo-order. The whole history reachable
+// from the commit is walked to find the children of each commit, once
+// per commit from which logs are paged; see [childCounts].
+func topoLog(r *git.Repository, from plumbing.Hash, n int, match func(*object.Commit) bool) ([]*object.Commit, error) {
+	start, err := r.CommitObject(from)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving commit: %w", err)
+	}
+	children, err := childCounts(start)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		commits []*object.Commit
+		stack   = []*object.Commit{start}
+		// walked counts the children walked of each commit
+		walked = make(map[plumbing.Hash]int)
+	)
+	for len(stack) > 0 && len(commits) < n {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if match(c) {
+			commits = append(commits, c)
+		}
+		for _, ph := range c.ParentHashes {
+			walked[ph] += 1
+			if walked[ph] < children[ph] {
+				continue
+			}
+			pc, err := r.CommitObject(ph)
+			if err != nil {
+				return nil, fmt.Errorf("error resolving parent commit: %w", err)
+			}
+			stack = append(stack, pc)
+		}
+	}
+	return commits, nil
+}
+
+// childCountsCacheEntries is the total number of commits whose child
+// counts are cached, across all histories.
+const childCountsCacheEntries = 1 << 20
+
+// childCountsCache caches the results of childCounts for the
+// histories most recently walked, keyed by the hash of the commit from
+// which each was walked. Histories never change, so neither do the
+// results.
+var childCountsCache = struct {
+	sync.Mutex
+	// hashes are the keys of entries, oldest first
+	hashes  []plumbing.Hash
+	entries map[plumbing.Hash]map[plumbing.Hash]int
+	// size is the total number of commits in entries
+	size int
+}{entries: make(map[plumbing.Hash]map[plumbing.Hash]int)}
+
+// childCounts returns the number of children of each commit reachable
+// from start within that history. The returned map must not be
+// modified.
+func childCounts(start *object.Commit) (map[plumbing.Hash]int, error) {
+	childCountsCache.Lock()
+	children, ok := childCountsCache.entries[start.Hash]
+	childCountsCache.Unlock()
+	if ok {
+		return children, nil
+	}
+
+	children = make(map[plumbing.Hash]int)
+	err := object.NewCommitPreorderIter(start, nil, nil).ForEach(func(c *object.Commit) error {
+		for _, ph := range c.ParentHashes {
+			children[ph] += 1
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error walking history: %w", err)
+	}
+
+	cacheChildCounts(start.Hash, children, childCountsCacheEntries)
+	return children, nil
+}
+
+// cacheChildCounts caches the child counts of the history walked from
+// hash, evicting the oldest histories cached until the total number of
+// commits cached is at most maxEntries. Histories of more commits are
+// not cached.
+func cacheChildCounts(hash plumbing.Hash, children map[plumbing.Hash]int, maxEntries int) {
+	childCountsCache.Lock()
+	defer childCountsCache.Unlock()
+	if _, ok := childCountsCache.entries[hash]; ok || len(children) > maxEntries {
+		return
+	}
+	for childCountsCache.size+len(children) > maxEntries {
+		oldest := childCountsCache.hashes[0]
+		childCountsCache.size -= len(childCountsCache.entries[oldest])
+		delete(childCountsCache.entries, oldest)
+		childCountsCache.hashes = childCountsCache.hashes[1:]
+	}
+	childCountsCache.hashes = append(childCountsCache.hashes, hash)
+	childCountsCache.entries[hash] = children
+	childCountsCache.size += len(children)
+}
@@ -0,0 +1,116 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"reflect"
+	"testing"
+
+	"djmo.ch/dgit/data"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestSetGraph(t *testing.T) {
+	// M merges B into A, both of which fork from R.
+	commits := []data.Commit{
+		{Hash: "M", ParentHashes: []data.Hash{"A", "B"}},
+		{Hash: "B", ParentHashes: []data.Hash{"R"}},
+		{Hash: "A", ParentHashes: []data.Hash{"R"}},
+		{Hash: "R"},
+	}
+	setGraph(commits)
+	exp := []data.GraphRow{
+		{Column: 0, Columns: 2, Color: 0, Edges: []data.GraphEdge{
+			{From: 0, To: 0, Span: data.SpanOut, Color: 0},
+			{From: 0, To: 1, Span: data.SpanOut, Color: 1},
+		}},
+		{Column: 1, Columns: 2, Color: 1, Edges: []data.GraphEdge{
+			{From: 0, To: 0, Span: data.SpanThrough, Color: 0},
+			{From: 1, To: 1, Span: data.SpanIn, Color: 1},
+			{From: 1, To: 1, Span: data.SpanOut, Color: 1},
+		}},
+		{Column: 0, Columns: 2, Color: 0, Edges: []data.GraphEdge{
+			{From: 0, To: 0, Span: data.SpanIn, Color: 0},
+			{From: 1, To: 1, Span: data.SpanThrough, Color: 1},
+			{From: 0, To: 0, Span: data.SpanOut, Color: 0},
+		}},
+		{Column: 0, Columns: 2, Color: 0, Fork: true, Edges: []data.GraphEdge{
+			{From: 0, To: 0, Span: data.SpanIn, Color: 0},
+			{From: 1, To: 0, Span: data.SpanIn, Color: 1},
+		}},
+	}
+	for i, c := range commits {
+		if !reflect.DeepEqual(*c.Graph, exp[i]) {
+			t.Errorf("row %d: exp=%+v, act=%+v", i, exp[i], *c.Graph)
+		}
+	}
+}
+
+func TestSetGraphTips(t *testing.T) {
+	// A and B are unrelated tips, and M merges a parent, B, which
+	// already has a lane.
+	commits := []data.Commit{
+		{Hash: "A", ParentHashes: []data.Hash{"B"}},
+		{Hash: "M", ParentHashes: []data.Hash{"C", "B"}},
+		{Hash: "B"},
+		{Hash: "C"},
+	}
+	setGraph(commits)
+	if row := commits[1].Graph; row.Column != 1 || len(row.Edges) != 3 {
+		t.Fatalf("expected second tip in column 1 with 3 edges, but got %+v", *row)
+	}
+	if out := commits[1].Graph.Edges[2]; out.Span != data.SpanOut || out.To != 0 {
+		t.Fatalf("expected merge edge joining lane 0, but got %+v", out)
+	}
+	if row := commits[2].Graph; !row.Fork || row.Column != 0 {
+		t.Fatalf("expected fork at column 0, but got %+v", *row)
+	}
+}
+
+func TestChildCounts(t *testing.T) {
+	tr := newTestRepo(t)
+	root := tr.commit("root", nil)
+	a := tr.commit("a", nil, root)
+	b := tr.commit("b", nil, root)
+	merge := tr.commit("merge", nil, a, b)
+	c, err := tr.r.CommitObject(merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[plumbing.Hash]int{root: 2, a: 1, b: 1}
+	children, err := childCounts(c)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if !reflect.DeepEqual(children, exp) {
+		t.Fatal("exp=", exp, "act=", children)
+	}
+	childCountsCache.Lock()
+	cached := childCountsCache.entries[merge]
+	childCountsCache.Unlock()
+	if !reflect.DeepEqual(cached, exp) {
+		t.Fatal("expected counts cached, but got", cached)
+	}
+}
+
+func TestCacheChildCounts(t *testing.T) {
+	var (
+		a = plumbing.ComputeHash(plumbing.BlobObject, []byte("a"))
+		b = plumbing.ComputeHash(plumbing.BlobObject, []byte("b"))
+		c = plumbing.ComputeHash(plumbing.BlobObject, []byte("c"))
+	)
+	childCountsCache.Lock()
+	size := childCountsCache.size
+	childCountsCache.Unlock()
+
+	cacheChildCounts(a, map[plumbing.Hash]int{b: 1}, size+1)
+	cacheChildCounts(b, map[plumbing.Hash]int{a: 1, c: 1}, 2)
+	cacheChildCounts(c, map[plumbing.Hash]int{a: 1, b: 1, c: 1}, 2)
+	childCountsCache.Lock()
+	defer childCountsCache.Unlock()
+	if len(childCountsCache.entries) != 1 || childCountsCache.entries[b] == nil || childCountsCache.size != 2 {
+		t.Fatalf("expected only b cached, but got %d histories of %d commits",
+			len(childCountsCache.entries), childCountsCache.size)
+	}
+}
@@ -0,0 +1,121 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"path"
+	"strings"
+
+	"djmo.ch/dgit/config"
+	"djmo.ch/dgit/data"
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// highlightClassPrefix prefixes the CSS classes of highlighted
+// tokens. Class names are otherwise the short names used by Pygments
+// and Chroma, e.g. hl-k for keywords.
+const highlightClassPrefix = "hl-"
+
+// highlightBlob highlights the lines of b, read from f, if a lexer
+// matches f and f is no larger than permitted by cfg.
+func highlightBlob(b *data.Blob, f *object.File, cfg config.Config) error {
+	maxSize := cfg.HighlightMaxSize
+	if maxSize == 0 {
+		maxSize = config.DefaultHighlightMaxSize
+	}
+	if maxSize < 0 || f.Size > maxSize {
+		return nil
+	}
+	if isBinary, err := f.IsBinary(); err != nil || isBinary {
+		return err
+	}
+	contents, err := f.Contents()
+	if err != nil {
+		return fmt.Errorf("error getting file contents: %w", err)
+	}
+	l := matchLexer(f.Name, contents)
+	if l == nil {
+		return nil
+	}
+	lines, err := highlight(l, contents)
+	if err != nil {
+		return fmt.Errorf("error highlighting %s: %w", f.Name, err)
+	}
+	for i := range b.Lines {
+		if i < len(lines) {
+			b.Lines[i].HTML = lines[i]
+		}
+	}
+	b.Language = l.Config().Name
+	return nil
+}
+
+// matchLexer returns the lexer for the file called name, chosen by
+// file name or extension, or else by the shebang line of contents.
+// It returns nil if no lexer matches.
+func matchLexer(name, contents string) chroma.Lexer {
+	if l := lexers.Match(path.Base(name)); l != nil {
+		return l
+	}
+	if strings.HasPrefix(contents, "#!") {
+		shebang, _, _ := strings.Cut(contents, "\n")
+		return lexers.Analyse(shebang)
+	}
+	return nil
+}
+
+// highlight tokenizes contents using lexer l and returns one HTML
+// fragment per line. Tokens are wrapped in spans with a class naming
+// their type.
+func highlight(l chroma.Lexer, contents string) ([]template.HTML, error) {
+	iter, err := chroma.Coalesce(l).Tokenise(nil, contents)
+	if err != nil {
+		return nil, fmt.Errorf("error tokenizing: %w", err)
+	}
+	var (
+		tokenLines = chroma.SplitTokensIntoLines(iter.Tokens())
+		lines      = make([]template.HTML, len(tokenLines))
+		sb         = new(strings.Builder)
+	)
+	for i, tokens := range tokenLines {
+		sb.Reset()
+		for _, token := range tokens {
+			value := strings.TrimRight(token.Value, "\r\n")
+			if value == "" {
+				continue
+			}
+			class := tokenClass(token.Type)
+			if class == "" {
+				sb.WriteString(html.EscapeString(value))
+				continue
+			}
+			fmt.Fprintf(sb, `<span class="%s%s">%s</span>`,
+				highlightClassPrefix, class, html.EscapeString(value))
+		}
+		lines[i] = template.HTML(sb.String())
+	}
+	return lines, nil
+}
+
+// tokenClass returns the short class name for t, falling back to the
+// names of its parent types. Text, including whitespace, has no
+// class.
+func tokenClass(t chroma.TokenType) string {
+	for {
+		if t.InCategory(chroma.Text) {
+			return ""
+		}
+		if class, ok := chroma.StandardTypes[t]; ok {
+			return class
+		}
+		if t == t.Parent() {
+			return ""
+		}
+		t = t.Parent()
+	}
+}
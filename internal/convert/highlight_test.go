@@ -0,0 +1,46 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"testing"
+)
+
+func TestMatchLexer(t *testing.T) {
+	for _, entry := range []struct {
+		name, contents, lexer string
+	}{
+		{name: "cmd/main.go", contents: "package main\n", lexer: "Go"},
+		{name: "Makefile", contents: "all:\n", lexer: "Makefile"},
+		{name: "bin/run", contents: "#!/bin/sh\necho hi\n", lexer: "Bash"},
+		{name: "notes", contents: "plain text\n"},
+	} {
+		l := matchLexer(entry.name, entry.contents)
+		switch {
+		case l == nil && entry.lexer != "":
+			t.Errorf("%s: expected %s lexer, but got none", entry.name, entry.lexer)
+		case l != nil && l.Config().Name != entry.lexer:
+			t.Errorf("%s: expected %q lexer, but got %q", entry.name, entry.lexer, l.Config().Name)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	l := matchLexer("main.go", "")
+	lines, err := highlight(l, "package main\n\n// x < y\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, but got %d", len(lines))
+	}
+	if exp := `<span class="hl-kn">package</span> <span class="hl-nx">main</span>`; string(lines[0]) != exp {
+		t.Errorf("expected %s, but got %s", exp, lines[0])
+	}
+	if lines[1] != "" {
+		t.Errorf("expected empty line, but got %s", lines[1])
+	}
+	if exp := `<span class="hl-c1">// x &lt; y</span>`; string(lines[2]) != exp {
+		t.Errorf("expected %s, but got %s", exp, lines[2])
+	}
+}
@@ -0,0 +1,162 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"cmp"
+	"encoding/hex"
+	"html"
+	"html/template"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"djmo.ch/dgit/config"
+	"djmo.ch/dgit/internal/repo"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// hashPattern matches words that may abbreviate commit hashes. Those
+// of digits alone, such as dates, are not taken for hashes; see
+// [isHashWord].
+var hashPattern = regexp.MustCompile(`\b[0-9a-f]{7,40}\b`)
+
+// linkSchemes are the URL schemes of links produced by link rules.
+// Links with other schemes, such as javascript:, are dropped.
+var linkSchemes = []string{"", "http", "https", "mailto"}
+
+// linker renders commit messages as HTML, linking the text matched by
+// the configured link rules and the hashes of commits in the
+// repository. A nil *linker links nothing.
+type linker struct {
+	r     *git.Repository
+	slug  string
+	rules []config.LinkRule
+	// commits caches the full hashes of the commits named by the hex
+	// words looked up, empty for words naming no commit.
+	commits map[string]string
+}
+
+// newLinker returns the linker for repo, as configured by cfg.
+func newLinker(repo *repo.Repo, cfg config.Config) *linker {
+	return &linker{
+		r:       repo.R,
+		slug:    repo.Slug,
+		rules:   cfg.LinkRules,
+		commits: make(map[string]string),
+	}
+}
+
+// textLink is a link from text[start:end] to href.
+type textLink struct {
+	start, end int
+	href       string
+}
+
+// link returns text as HTML, with links added. Where matches overlap,
+// the earliest is linked, and of those starting together, that of the
+// first rule. Commit hashes follow the configured rules.
+func (l *linker) link(text string) template.HTML {
+	if l == nil {
+		return template.HTML(html.EscapeString(text))
+	}
+	var links []textLink
+	for _, rule := range l.rules {
+		for _, m := range rule.Pattern.FindAllStringSubmatchIndex(text, -1) {
+			if m[0] == m[1] {
+				continue
+			}
+			href := string(rule.Pattern.ExpandString(nil, rule.URL, text, m))
+			if u, err := url.Parse(href); err != nil || !slices.Contains(linkSchemes, u.Scheme) {
+				continue
+			}
+			links = append(links, textLink{start: m[0], end: m[1], href: href})
+		}
+	}
+	for _, m := range hashPattern.FindAllStringIndex(text, -1) {
+		if !isHashWord(text[m[0]:m[1]]) {
+			continue
+		}
+		if hash := l.commit(text[m[0]:m[1]]); hash != "" {
+			links = append(links, textLink{
+				start: m[0],
+				end:   m[1],
+				href:  "/" + l.slug + "/-/commit/" + hash,
+			})
+		}
+	}
+	slices.SortStableFunc(links, func(a, b textLink) int {
+		return cmp.Compare(a.start, b.start)
+	})
+
+	var (
+		b   strings.Builder
+		pos int
+	)
+	for _, lk := range links {
+		if lk.start < pos {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:lk.start]))
+		b.WriteString(`<a href="`)
+		b.WriteString(html.EscapeString(lk.href))
+		b.WriteString(`">`)
+		b.WriteString(html.EscapeString(text[lk.start:lk.end]))
+		b.WriteString(`</a>`)
+		pos = lk.end
+	}
+	b.WriteString(html.EscapeString(text[pos:]))
+	return template.HTML(b.String())
+}
+
+// isHashWord reports whether the word matched by hashPattern has a
+// letter, and so is not a number.
+func isHashWord(word string) bool {
+	return strings.ContainsAny(word, "abcdef")
+}
+
+// hashPrefixer is implemented by object storage able to find the
+// objects whose hashes start with a prefix, as that of repositories
+// on disk is.
+type hashPrefixer interface {
+	HashesWithPrefix(prefix []byte) ([]plumbing.Hash, error)
+}
+
+// commit returns the full hash of the commit named by the hex word,
+// or an empty string if it names no commit, or more than one. Only
+// object hashes are considered, not refs named like them, and objects
+// other than commits, such as annotated tags, are not followed.
+func (l *linker) commit(word string) string {
+	if hash, ok := l.commits[word]; ok {
+		return hash
+	}
+	var candidates []plumbing.Hash
+	if len(word) == 2*len(plumbing.ZeroHash) {
+		candidates = []plumbing.Hash{plumbing.NewHash(word)}
+	} else if hp, ok := l.r.Storer.(hashPrefixer); ok {
+		// Only whole bytes can be decoded; a final nibble is checked
+		// below.
+		prefix, err := hex.DecodeString(word[:len(word)&^1])
+		if err == nil {
+			candidates, _ = hp.HashesWithPrefix(prefix)
+		}
+	}
+	var full string
+	for _, hash := range candidates {
+		if !strings.HasPrefix(hash.String(), word) {
+			continue
+		}
+		if _, err := l.r.CommitObject(hash); err != nil {
+			continue
+		}
+		if full != "" {
+			full = ""
+			break
+		}
+		full = hash.String()
+	}
+	l.commits[word] = full
+	return full
+}
@@ -0,0 +1,68 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+	"testing"
+
+	"djmo.ch/dgit/config"
+	"djmo.ch/dgit/internal/repo"
+)
+
+func TestLink(t *testing.T) {
+	tr := newTestRepo(t)
+	hash := tr.commit("root", nil)
+	full, short := hash.String(), hash.String()[:7]
+	commitLink := `<a href="/repo/-/commit/` + full + `">`
+
+	cfg := config.Config{LinkRules: []config.LinkRule{
+		{Pattern: regexp.MustCompile(`#(\d+)`), URL: "https://bugs.example.com/$1"},
+		{Pattern: regexp.MustCompile(`#\d+`), URL: "https://other.example.com/"},
+		{Pattern: regexp.MustCompile(`CVE-\d+-\d+`), URL: "https://www.cve.org/CVERecord?id=$0"},
+		{Pattern: regexp.MustCompile(`evil\((\S+)\)`), URL: "$1"},
+	}}
+	links := newLinker(&repo.Repo{R: tr.r, Slug: "repo"}, cfg)
+
+	tests := []struct {
+		text string
+		want template.HTML
+	}{
+		{"plain <text> & more", "plain &lt;text&gt; &amp; more"},
+		{"Fixes #12.", `Fixes <a href="https://bugs.example.com/12">#12</a>.`},
+		{"CVE-2024-1234", `<a href="https://www.cve.org/CVERecord?id=CVE-2024-1234">CVE-2024-1234</a>`},
+		{"Reverts " + short, "Reverts " + template.HTML(commitLink) + template.HTML(short) + "</a>"},
+		{"Reverts " + full, "Reverts " + template.HTML(commitLink) + template.HTML(full) + "</a>"},
+		{"Not a commit: deadbeef", "Not a commit: deadbeef"},
+		{"evil(javascript:alert(1))", "evil(javascript:alert(1))"},
+	}
+	for _, tt := range tests {
+		if got := links.link(tt.text); got != tt.want {
+			t.Errorf("link(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	// A commit whose abbreviated hash is all digits, like a date
+	var digits string
+	for i := 0; digits == "" && i < 1000; i += 1 {
+		prefix := tr.commit(fmt.Sprint("commit ", i), nil, hash).String()[:8]
+		if strings.Trim(prefix, "0123456789") == "" {
+			digits = prefix
+		}
+	}
+	if digits == "" {
+		t.Fatal("no commit with an all-digit abbreviated hash")
+	}
+	links = newLinker(&repo.Repo{R: tr.r, Slug: "repo"}, cfg)
+	if got, want := links.link("Released "+digits), template.HTML("Released "+digits); got != want {
+		t.Errorf("link(%q) = %q, want %q", "Released "+digits, got, want)
+	}
+
+	var nilLinker *linker
+	if got, want := nilLinker.link("#1 <b>"), template.HTML("#1 &lt;b&gt;"); got != want {
+		t.Errorf("nil link = %q, want %q", got, want)
+	}
+}
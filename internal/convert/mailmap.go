@@ -0,0 +1,200 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"djmo.ch/dgit/config"
+	"djmo.ch/dgit/internal/mailmap"
+	"djmo.ch/dgit/internal/repo"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// defaultMailmapBlob is the mailmap read when mailmap.blob is unset,
+// as by git in a bare repository.
+const defaultMailmapBlob = "HEAD:.mailmap"
+
+// identities converts the identities of commit authors and
+// committers.
+type identities struct {
+	// The repository's mailmap, which may be nil
+	mailmap *mailmap.Mailmap
+	// The avatar provider, which may be nil
+	avatars config.AvatarProvider
+}
+
+// newIdentities returns the identities of repo, as configured by cfg.
+// A mailmap that cannot be loaded is logged and ignored, rather than
+// failing the page.
+func newIdentities(repo *repo.Repo, cfg config.Config) identities {
+	mm, err := loadMailmap(repo.R)
+	if err != nil {
+		log.Printf("ERROR: failed to load mailmap of %s: %v", repo.Slug, err)
+	}
+	return identities{mailmap: mm, avatars: cfg.Avatars}
+}
+
+// mailmapKey identifies the sources of a mailmap: the hash of its
+// blob, which is zero if there is none, and the path, modification
+// time and size of its file, which are zero if there is none.
+type mailmapKey struct {
+	blob    plumbing.Hash
+	file    string
+	modTime time.Time
+	size    int64
+}
+
+// mailmapCacheSize is the number of mailmaps cached.
+const mailmapCacheSize = 64
+
+// mailmapCache caches the mailmaps parsed by loadMailmap, keyed by
+// their sources.
+var mailmapCache = struct {
+	sync.Mutex
+	entries map[mailmapKey]*mailmap.Mailmap
+}{entries: make(map[mailmapKey]*mailmap.Mailmap)}
+
+// loadMailmap reads the mailmap of r from the blob named by the
+// mailmap.blob option of its Git config, HEAD:.mailmap by default, and
+// then from the file named by mailmap.file, if set. The file is
+// resolved relative to the Git directory of r, and is refused if it is
+// outside it, so that a repository cannot read arbitrary files of the
+// host. Missing mailmaps are ignored. Mailmaps are parsed again only
+// when their blob or file changes.
+func loadMailmap(r *git.Repository) (*mailmap.Mailmap, error) {
+	gc, err := r.Config()
+	if err != nil {
+		return nil, fmt.Errorf("error reading repository config: %w", err)
+	}
+	var (
+		key     mailmapKey
+		section = gc.Raw.Section("mailmap")
+		blob    = section.Option("blob")
+	)
+	if blob == "" {
+		blob = defaultMailmapBlob
+	}
+	if key.blob, err = mailmapBlob(r, blob); err != nil {
+		return nil, err
+	}
+	var (
+		file = section.Option("file")
+		root *os.Root
+	)
+	if file != "" {
+		if root, err = gitDirRoot(r); err != nil {
+			return nil, err
+		}
+	}
+	if root != nil {
+		defer root.Close()
+		info, err := root.Stat(file)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return nil, fmt.Errorf("error reading mailmap file: %w", err)
+		default:
+			key.file = filepath.Join(root.Name(), file)
+			key.modTime, key.size = info.ModTime(), info.Size()
+		}
+	}
+	mailmapCache.Lock()
+	mm, ok := mailmapCache.entries[key]
+	mailmapCache.Unlock()
+	if ok {
+		return mm, nil
+	}
+
+	mm = new(mailmap.Mailmap)
+	if !key.blob.IsZero() {
+		contents, err := readBlobContents(key.blob, r)
+		if err != nil {
+			return nil, err
+		}
+		if err = mm.Parse(strings.NewReader(contents)); err != nil {
+			return nil, err
+		}
+	}
+	if key.file != "" {
+		f, err := root.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading mailmap file: %w", err)
+		}
+		defer f.Close()
+		if err = mm.Parse(f); err != nil {
+			return nil, err
+		}
+	}
+
+	mailmapCache.Lock()
+	defer mailmapCache.Unlock()
+	if len(mailmapCache.entries) >= mailmapCacheSize {
+		// Any entry will do, as stale entries are never used
+		for k := range mailmapCache.entries {
+			delete(mailmapCache.entries, k)
+			break
+		}
+	}
+	mailmapCache.entries[key] = mm
+	return mm, nil
+}
+
+// gitDirRoot opens the Git directory of r, or returns nil if r is not
+// stored in one.
+func gitDirRoot(r *git.Repository) (*os.Root, error) {
+	s, ok := r.Storer.(*filesystem.Storage)
+	if !ok {
+		return nil, nil
+	}
+	root, err := os.OpenRoot(s.Filesystem().Root())
+	if err != nil {
+		return nil, fmt.Errorf("error opening Git directory: %w", err)
+	}
+	return root, nil
+}
+
+// mailmapBlob returns the hash of the blob named by name, either a blob
+// hash or a revision and path separated by a colon. It returns the
+// zero hash if there is no such blob.
+func mailmapBlob(r *git.Repository, name string) (plumbing.Hash, error) {
+	rev, path, ok := strings.Cut(name, ":")
+	if !ok {
+		b, err := r.BlobObject(plumbing.NewHash(name))
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			return plumbing.ZeroHash, nil
+		}
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("error resolving mailmap blob: %w", err)
+		}
+		return b.Hash, nil
+	}
+	hash, err := r.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		// An empty repository has no HEAD, and so no mailmap.
+		return plumbing.ZeroHash, nil
+	}
+	c, err := r.CommitObject(*hash)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("error resolving mailmap commit: %w", err)
+	}
+	f, err := c.File(path)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return plumbing.ZeroHash, nil
+	}
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("error resolving mailmap file: %w", err)
+	}
+	return f.Hash, nil
+}
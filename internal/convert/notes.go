@@ -0,0 +1,126 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"djmo.ch/dgit/data"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// notesTree is the tree of notes at the tip of a notes ref.
+type notesTree struct {
+	// The ref name without its refs/notes/ prefix
+	name string
+	tree *object.Tree
+}
+
+// loadNotes returns the trees of the notes refs of r named or matched
+// by patterns, in order, each pattern's matches sorted by name. Refs
+// that do not exist are ignored.
+func loadNotes(r *git.Repository, patterns []string) ([]notesTree, error) {
+	var names []string
+	for _, pattern := range patterns {
+		if !strings.ContainsAny(pattern, `*?[\`) {
+			names = append(names, pattern)
+			continue
+		}
+		refs, err := r.References()
+		if err != nil {
+			return nil, fmt.Errorf("error listing notes refs: %w", err)
+		}
+		var matches []string
+		err = refs.ForEach(func(ref *plumbing.Reference) error {
+			if ok, _ := path.Match(pattern, ref.Name().String()); ok {
+				matches = append(matches, ref.Name().String())
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing notes refs: %w", err)
+		}
+		slices.Sort(matches)
+		names = append(names, matches...)
+	}
+
+	var notes []notesTree
+	for _, name := range names {
+		if slices.ContainsFunc(notes, func(n notesTree) bool { return n.name == shortNotesRef(name) }) {
+			continue
+		}
+		ref, err := r.Reference(plumbing.ReferenceName(name), true)
+		if err != nil {
+			continue
+		}
+		c, err := r.CommitObject(ref.Hash())
+		if err != nil {
+			return nil, fmt.Errorf("error resolving notes commit for %s: %w", name, err)
+		}
+		tree, err := c.Tree()
+		if err != nil {
+			return nil, fmt.Errorf("error resolving notes tree for %s: %w", name, err)
+		}
+		notes = append(notes, notesTree{name: shortNotesRef(name), tree: tree})
+	}
+	return notes, nil
+}
+
+func shortNotesRef(name string) string {
+	return strings.TrimPrefix(name, "refs/notes/")
+}
+
+// commitNotes returns the notes on the commit with the given hash
+// found in notes, in order.
+func commitNotes(r *git.Repository, notes []notesTree, hash plumbing.Hash, links *linker) ([]data.Note, error) {
+	var found []data.Note
+	for _, n := range notes {
+		blob, ok, err := findNote(r, n.tree, hash.String())
+		if err != nil {
+			return nil, fmt.Errorf("error reading notes for %s: %w", n.name, err)
+		}
+		if !ok {
+			continue
+		}
+		text, err := readBlobContents(blob, r)
+		if err != nil {
+			return nil, err
+		}
+		text = strings.TrimRight(text, "\n")
+		found = append(found, data.Note{Ref: n.name, Text: text, LinkedText: links.link(text)})
+	}
+	return found, nil
+}
+
+// findNote returns the hash of the note blob for the object with the
+// given hex hash in the notes tree t. Notes are named by the hash, or
+// are fanned out into subtrees named by its leading pairs of hex
+// digits, as in ab/cdef....
+func findNote(r *git.Repository, t *object.Tree, hash string) (plumbing.Hash, bool, error) {
+	for len(hash) > 2 {
+		var sub *object.TreeEntry
+		for i, e := range t.Entries {
+			switch {
+			case e.Name == hash && e.Mode.IsFile():
+				return e.Hash, true, nil
+			case e.Name == hash[:2] && e.Mode == filemode.Dir:
+				sub = &t.Entries[i]
+			}
+		}
+		if sub == nil {
+			return plumbing.ZeroHash, false, nil
+		}
+		var err error
+		if t, err = r.TreeObject(sub.Hash); err != nil {
+			return plumbing.ZeroHash, false, fmt.Errorf("error resolving notes subtree: %w", err)
+		}
+		hash = hash[2:]
+	}
+	return plumbing.ZeroHash, false, nil
+}
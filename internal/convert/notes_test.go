@@ -0,0 +1,100 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"reflect"
+	"testing"
+
+	"djmo.ch/dgit/data"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestCommitNotes(t *testing.T) {
+	tr := newTestRepo(t)
+	r := tr.r
+	hash := tr.commit("root", nil)
+
+	store := func(o object.Object) plumbing.Hash {
+		t.Helper()
+		obj := r.Storer.NewEncodedObject()
+		if err := o.Encode(obj); err != nil {
+			t.Fatal(err)
+		}
+		h, err := r.Storer.SetEncodedObject(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	blob := func(text string) plumbing.Hash {
+		t.Helper()
+		obj := r.Storer.NewEncodedObject()
+		obj.SetType(plumbing.BlobObject)
+		w, err := obj.Writer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write([]byte(text)); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		h, err := r.Storer.SetEncodedObject(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	notesRef := func(name string, tree plumbing.Hash) {
+		t.Helper()
+		sig := tr.sig()
+		c := store(&object.Commit{Author: *sig, Committer: *sig, Message: "Notes added", TreeHash: tree})
+		if err := r.Storer.SetReference(plumbing.NewHashReference(plumbing.ReferenceName(name), c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := hash.String()
+	notesRef("refs/notes/commits", store(&object.Tree{Entries: []object.TreeEntry{
+		{Name: h, Mode: filemode.Regular, Hash: blob("Tested-on: amd64\n")},
+	}}))
+	fanout := store(&object.Tree{Entries: []object.TreeEntry{
+		{Name: h[2:], Mode: filemode.Regular, Hash: blob("LGTM")},
+	}})
+	notesRef("refs/notes/review", store(&object.Tree{Entries: []object.TreeEntry{
+		{Name: h[:2], Mode: filemode.Dir, Hash: fanout},
+	}}))
+	notesRef("refs/notes/other", store(&object.Tree{}))
+
+	tests := []struct {
+		refs []string
+		want []data.Note
+	}{
+		{nil, nil},
+		{[]string{"refs/notes/commits"}, []data.Note{
+			{Ref: "commits", Text: "Tested-on: amd64", LinkedText: "Tested-on: amd64"},
+		}},
+		{[]string{"refs/notes/review", "refs/notes/missing"}, []data.Note{
+			{Ref: "review", Text: "LGTM", LinkedText: "LGTM"},
+		}},
+		{[]string{"refs/notes/review", "refs/notes/*"}, []data.Note{
+			{Ref: "review", Text: "LGTM", LinkedText: "LGTM"},
+			{Ref: "commits", Text: "Tested-on: amd64", LinkedText: "Tested-on: amd64"},
+		}},
+	}
+	for _, tt := range tests {
+		notes, err := loadNotes(r, tt.refs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := commitNotes(r, notes, hash, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("notes from %v = %+v, want %+v", tt.refs, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,428 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"djmo.ch/dgit/config"
+	"djmo.ch/dgit/internal/repo"
+	"djmo.ch/dgit/internal/request"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// patchDate is the format of the Date header of a patch, as written
+// by git format-patch.
+const patchDate = "Mon, 2 Jan 2006 15:04:05 -0700"
+
+// WritePatch writes the commits requested by req to w as an mbox, in
+// the format of git format-patch, suitable for git am. A single commit
+// is written for req.Revision. Otherwise, each non-merge commit
+// reachable from req.DiffTo but not from req.DiffFrom is written,
+// oldest first. ErrDiffTooLarge is returned, before anything is
+// written, if there are more commits than cfg.MaxPatchCommits or any
+// patch exceeds the limits configured for diffs.
+func WritePatch(w io.Writer, repo *repo.Repo, req *request.Request, cfg config.Config) error {
+	var commits []*object.Commit
+	if req.Revision != "" {
+		hash, err := toCommitHash(req.Revision, repo.R)
+		if err != nil {
+			return err
+		}
+		c, err := repo.R.CommitObject(hash)
+		if err != nil {
+			return fmt.Errorf("error resolving commit: %w", err)
+		}
+		commits = append(commits, c)
+	} else {
+		from, err := toCommitHash(req.DiffFrom, repo.R)
+		if err != nil {
+			return err
+		}
+		to, err := toCommitHash(req.DiffTo, repo.R)
+		if err != nil {
+			return err
+		}
+		var (
+			maxCommits = limit(cfg.MaxPatchCommits, config.DefaultMaxPatchCommits)
+			more       bool
+		)
+		if commits, more, err = revList(repo.R, to, from, maxCommits); err != nil {
+			return err
+		}
+		if more {
+			return fmt.Errorf("%w: more than %d commits", ErrDiffTooLarge, maxCommits)
+		}
+		commits = slices.DeleteFunc(commits, func(c *object.Commit) bool {
+			return c.NumParents() > 1
+		})
+		slices.Reverse(commits)
+	}
+
+	// Every patch is checked against the limits before any is
+	// written, as an error cannot be reported once writing has
+	// begun. Only the patch of a single commit is kept, rather than
+	// generated again, to bound the memory held.
+	var (
+		patch *object.Patch
+		err   error
+	)
+	for _, c := range commits {
+		if patch, err = commitPatch(c, repo.R, cfg); err != nil {
+			return err
+		}
+	}
+	for i, c := range commits {
+		if len(commits) > 1 {
+			if patch, err = commitPatch(c, repo.R, cfg); err != nil {
+				return err
+			}
+		}
+		prefix := "[PATCH]"
+		if len(commits) > 1 {
+			prefix = fmt.Sprintf("[PATCH %d/%d]", i+1, len(commits))
+		}
+		if err = writeMail(w, c, prefix, patch); err != nil {
+			return fmt.Errorf("error writing patch for %s: %w", c.Hash, err)
+		}
+	}
+	return nil
+}
+
+// commitPatch returns the patch of commit c against its first parent,
+// if any.
+func commitPatch(c *object.Commit, r *git.Repository, cfg config.Config) (*object.Patch, error) {
+	var parentTree *object.Tree
+	if c.NumParents() > 0 {
+		pc, err := c.Parent(0)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving parent commit: %w", err)
+		}
+		if parentTree, err = pc.Tree(); err != nil {
+			return nil, fmt.Errorf("error resolving parent commit tree: %w", err)
+		}
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("error resolving commit tree: %w", err)
+	}
+	return unifiedPatch(parentTree, tree, r, cfg)
+}
+
+// WriteDiff writes the diff from req.DiffFrom, or from its merge base
+// with req.DiffTo if req.MergeBase is true, to req.DiffTo to w as a
+// plain unified diff, ignoring whitespace if req.IgnoreWhitespace is
+// true. ErrDiffTooLarge is returned, before anything is written, if
+// the diff exceeds the limits configured for diffs.
+func WriteDiff(w io.Writer, repo *repo.Repo, req *request.Request, cfg config.Config) error {
+	_, to, base, err := rangeCommits(repo.R, req)
+	if err != nil {
+		return err
+	}
+	var trees [2]*object.Tree
+	for i, c := range []*object.Commit{base, to} {
+		if trees[i], err = c.Tree(); err != nil {
+			return fmt.Errorf("error resolving commit tree: %w", err)
+		}
+	}
+	patch, err := unifiedPatch(trees[0], trees[1], repo.R, cfg)
+	if err != nil {
+		return err
+	}
+	var p diff.Patch = patch
+	if req.IgnoreWhitespace {
+		fps := make([]filePatch, len(patch.FilePatches()))
+		for i, fp := range patch.FilePatches() {
+			fps[i].FilePatch = fp
+		}
+		if fps, err = ignoreWhitespace(fps, repo.R); err != nil {
+			return err
+		}
+		wp := make(filePatches, len(fps))
+		for i, fp := range fps {
+			wp[i] = fp.FilePatch
+		}
+		p = wp
+	}
+	if err = diff.NewUnifiedEncoder(w, diffContext(req, cfg)).Encode(p); err != nil {
+		return fmt.Errorf("error writing diff: %w", err)
+	}
+	return nil
+}
+
+// filePatches implements the [diff.Patch] interface for a list of
+// file patches.
+type filePatches []diff.FilePatch
+
+func (p filePatches) FilePatches() []diff.FilePatch { return p }
+func (p filePatches) Message() string               { return "" }
+
+// unifiedPatch returns the patch from tree a to tree b, either of
+// which may be nil, detecting renames as configured by cfg. Copies are
+// not detected, as tools applying the patch would take them for
+// renames. As a patch cannot be truncated without breaking it,
+// ErrDiffTooLarge is returned if it has more files than
+// cfg.MaxDiffFiles, any file larger than cfg.MaxBlobSize or more
+// changed lines in a file than cfg.MaxDiffLines.
+func unifiedPatch(a, b *object.Tree, r *git.Repository, cfg config.Config) (*object.Patch, error) {
+	changes, err := object.DiffTreeWithOptions(context.Background(), a, b, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error comparing trees: %w", err)
+	}
+	if maxFiles := limit(cfg.MaxDiffFiles, config.DefaultMaxDiffFiles); len(changes) > maxFiles {
+		return nil, fmt.Errorf("%w: more than %d files", ErrDiffTooLarge, maxFiles)
+	}
+	maxSize := sizeLimit(cfg.MaxBlobSize, config.DefaultMaxBlobSize)
+	for _, c := range changes {
+		for _, e := range []object.ChangeEntry{c.From, c.To} {
+			large, err := isLarge(e, r, maxSize)
+			if err != nil {
+				return nil, err
+			}
+			if large {
+				return nil, fmt.Errorf("%w: %s larger than %d bytes", ErrDiffTooLarge, e.Name, maxSize)
+			}
+		}
+	}
+	threshold := orDefault(cfg.RenameThreshold, config.DefaultRenameThreshold)
+	if threshold >= 0 {
+		changes, err = object.DetectRenames(changes, &object.DiffTreeOptions{
+			DetectRenames: true,
+			RenameScore:   uint(threshold),
+			RenameLimit:   renameLimit,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error detecting renames: %w", err)
+		}
+	}
+	patch, err := changes.Patch()
+	if err != nil {
+		return nil, fmt.Errorf("error generating patch: %w", err)
+	}
+	maxLines := limit(cfg.MaxDiffLines, config.DefaultMaxDiffLines)
+	for _, s := range patch.Stats() {
+		if s.Addition+s.Deletion > maxLines {
+			return nil, fmt.Errorf("%w: more than %d lines changed in %s", ErrDiffTooLarge, maxLines, s.Name)
+		}
+	}
+	return patch, nil
+}
+
+// writeMail writes c and its patch to w as a single mbox message, with
+// prefix preceding the subject.
+func writeMail(w io.Writer, c *object.Commit, prefix string, patch *object.Patch) error {
+	subject, body := splitMessage(c.Message)
+	from := mail.Address{Name: c.Author.Name, Address: c.Author.Email}
+	stats := patch.Stats()
+
+	sb := new(strings.Builder)
+	fmt.Fprintf(sb, "From %s Mon Sep 17 00:00:00 2001\n", c.Hash)
+	fmt.Fprintf(sb, "From: %s\n", from.String())
+	fmt.Fprintf(sb, "Date: %s\n", c.Author.When.Format(patchDate))
+	fmt.Fprintf(sb, "Subject: %s\n", mime.QEncoding.Encode("UTF-8", prefix+" "+subject))
+	sb.WriteString("MIME-Version: 1.0\n")
+	sb.WriteString("Content-Type: text/plain; charset=UTF-8\n")
+	sb.WriteString("Content-Transfer-Encoding: 8bit\n\n")
+	if body != "" {
+		sb.WriteString(body + "\n")
+	}
+	sb.WriteString("---\n")
+	sb.WriteString(stats.String())
+	sb.WriteString(statSummary(stats) + "\n\n")
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		return err
+	}
+	if err := diff.NewUnifiedEncoder(w, diff.DefaultContextLines).Encode(patch); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "-- \ndgit\n\n")
+	return err
+}
+
+// splitMessage splits a commit message into its subject, the first
+// paragraph joined into a single line, and its body.
+func splitMessage(message string) (subject, body string) {
+	message = strings.TrimSpace(message)
+	subject, body, _ = strings.Cut(message, "\n\n")
+	return strings.Join(strings.Fields(subject), " "), strings.TrimSpace(body)
+}
+
+// statSummary returns the summary line of a diffstat, as written by
+// git diff --stat.
+func statSummary(stats object.FileStats) string {
+	var adds, dels int
+	for _, s := range stats {
+		adds += s.Addition
+		dels += s.Deletion
+	}
+	summary := fmt.Sprintf(" %d %s changed", len(stats), plural(len(stats), "file", "files"))
+	if adds > 0 || dels == 0 {
+		summary += fmt.Sprintf(", %d %s(+)", adds, plural(adds, "insertion", "insertions"))
+	}
+	if dels > 0 || adds == 0 {
+		summary += fmt.Sprintf(", %d %s(-)", dels, plural(dels, "deletion", "deletions"))
+	}
+	return summary
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// revList returns the commits reachable from include but not from
+// exclude, newest first by committer time, as walked by [divergence].
+// No more than n commits are returned, and more is true if there are
+// others.
+func revList(r *git.Repository, include, exclude plumbing.Hash, n int) (commits []*object.Commit, more bool, err error) {
+	hashes, _, err := divergence(r, include, exclude)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(hashes) > n {
+		hashes, more = hashes[:n], true
+	}
+	commits = make([]*object.Commit, len(hashes))
+	for i, hash := range hashes {
+		if commits[i], err = r.CommitObject(hash); err != nil {
+			return nil, false, fmt.Errorf("error resolving commit: %w", err)
+		}
+	}
+	return commits, more, nil
+}
+
+// Marks of the commits walked by divergence, recording from which of
+// its two tips each is reachable, and whether it has been walked.
+const (
+	reachableA = 1 << iota
+	reachableB
+	walked
+
+	reachableBoth = reachableA | reachableB
+)
+
+// divergence returns the hashes of the commits reachable from a but
+// not from b, and of those reachable from b but not from a, newest
+// first by committer time. Both histories are walked together, newest
+// first, marking each commit with the tips from which it is reachable.
+// The walk ends near the merge bases of a and b rather than at their
+// roots: once every commit queued is reachable from both, and older
+// than every commit walked from only one, none of which it could then
+// reach unless committer times are skewed.
+func divergence(r *git.Repository, a, b plumbing.Hash) (onlyA, onlyB []plumbing.Hash, err error) {
+	var (
+		marks = make(map[plumbing.Hash]int)
+		queue = new(commitQueue)
+		// unshared is the number of queued commits not reachable
+		// from both tips
+		unshared int
+		// oldest is the time of the oldest commit walked from
+		// only one tip
+		oldest time.Time
+	)
+	mark := func(hash plumbing.Hash, m int) error {
+		old := marks[hash]
+		if old&m == m {
+			return nil
+		}
+		marks[hash] = old | m
+		switch {
+		case old == 0:
+			c, err := r.CommitObject(hash)
+			if err != nil {
+				return fmt.Errorf("error resolving commit: %w", err)
+			}
+			heap.Push(queue, c)
+			if m != reachableBoth {
+				unshared += 1
+			}
+		case old&walked == 0:
+			// Queued, and now reachable from both
+			unshared -= 1
+		default:
+			// Walked before a descendant, when committer times
+			// are skewed, so walked again to mark its ancestors
+			marks[hash] &^= walked
+			c, err := r.CommitObject(hash)
+			if err != nil {
+				return fmt.Errorf("error resolving commit: %w", err)
+			}
+			heap.Push(queue, c)
+		}
+		return nil
+	}
+	if err = mark(a, reachableA); err != nil {
+		return nil, nil, err
+	}
+	if err = mark(b, reachableB); err != nil {
+		return nil, nil, err
+	}
+	var walkedA, walkedB []plumbing.Hash
+	for queue.Len() > 0 {
+		if unshared == 0 && (oldest.IsZero() || (*queue)[0].Committer.When.Before(oldest)) {
+			break
+		}
+		c := heap.Pop(queue).(*object.Commit)
+		m := marks[c.Hash]
+		marks[c.Hash] = m | walked
+		if m != reachableBoth {
+			if m == reachableA {
+				walkedA = append(walkedA, c.Hash)
+			} else {
+				walkedB = append(walkedB, c.Hash)
+			}
+			unshared -= 1
+			if oldest.IsZero() || c.Committer.When.Before(oldest) {
+				oldest = c.Committer.When
+			}
+		}
+		for _, p := range c.ParentHashes {
+			if err = mark(p, m); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	// Commits found reachable from both after being walked are
+	// dropped.
+	for _, hash := range walkedA {
+		if marks[hash]&reachableBoth == reachableA {
+			onlyA = append(onlyA, hash)
+		}
+	}
+	for _, hash := range walkedB {
+		if marks[hash]&reachableBoth == reachableB {
+			onlyB = append(onlyB, hash)
+		}
+	}
+	return onlyA, onlyB, nil
+}
+
+// commitQueue is a [heap.Interface] of commits, newest first by
+// committer time.
+type commitQueue []*object.Commit
+
+func (q commitQueue) Len() int { return len(q) }
+func (q commitQueue) Less(i, j int) bool {
+	return q[i].Committer.When.After(q[j].Committer.When)
+}
+func (q commitQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x any)   { *q = append(*q, x.(*object.Commit)) }
+func (q *commitQueue) Pop() any {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,198 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"djmo.ch/dgit/config"
+	"djmo.ch/dgit/internal/repo"
+	"djmo.ch/dgit/internal/request"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestSplitMessage(t *testing.T) {
+	subject, body := splitMessage("Fix the\nthing\n\nIt was broken.\n\nSigned-off-by: A <a@example.com>\n")
+	if subject != "Fix the thing" {
+		t.Fatal("subject: exp=Fix the thing, act=", subject)
+	}
+	if body != "It was broken.\n\nSigned-off-by: A <a@example.com>" {
+		t.Fatal("unexpected body", body)
+	}
+	if _, body = splitMessage("Subject only\n"); body != "" {
+		t.Fatal("expected empty body, but got", body)
+	}
+}
+
+func TestStatSummary(t *testing.T) {
+	for _, tc := range []struct {
+		stats object.FileStats
+		exp   string
+	}{
+		{object.FileStats{{Addition: 1}}, " 1 file changed, 1 insertion(+)"},
+		{object.FileStats{{Deletion: 2}, {Deletion: 1}}, " 2 files changed, 3 deletions(-)"},
+		{object.FileStats{{Addition: 2, Deletion: 1}}, " 1 file changed, 2 insertions(+), 1 deletion(-)"},
+		{object.FileStats{{}}, " 1 file changed, 0 insertions(+), 0 deletions(-)"},
+	} {
+		if act := statSummary(tc.stats); act != tc.exp {
+			t.Errorf("exp=%q, act=%q", tc.exp, act)
+		}
+	}
+}
+
+func TestRangeCommits(t *testing.T) {
+	tr := newTestRepo(t)
+	r := tr.r
+	base := tr.commit("base", map[string]string{"file": "base\n"})
+	upstream := tr.commit("upstream", map[string]string{"file": "upstream\n"})
+	err := tr.wt.Checkout(&git.CheckoutOptions{
+		Hash:   base,
+		Branch: plumbing.NewBranchReferenceName("topic"),
+		Create: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.commit("topic", map[string]string{"topic": "topic\n"})
+	topic := tr.commit("more topic", map[string]string{"topic": "more topic\n"})
+
+	req := &request.Request{DiffFrom: upstream.String(), DiffTo: "topic"}
+	from, to, mb, err := rangeCommits(r, req)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if from.Hash != upstream || to.Hash != topic || mb.Hash != upstream {
+		t.Fatal("expected endpoints, but got", from.Hash, to.Hash, mb.Hash)
+	}
+	req.MergeBase = true
+	if from, _, mb, err = rangeCommits(r, req); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if from.Hash != upstream || mb.Hash != base {
+		t.Fatal("merge base: exp=", base, "act=", mb.Hash)
+	}
+
+	ahead, more, err := revList(r, topic, upstream, 2)
+	if err != nil || more {
+		t.Fatal("unexpected error or more commits", err, more)
+	}
+	behind, _, err := revList(r, upstream, topic, 2)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if len(ahead) != 2 || len(behind) != 1 {
+		t.Fatal("expected 2 ahead and 1 behind, but got", len(ahead), len(behind))
+	}
+	if ahead[0].Hash != topic || behind[0].Hash != upstream {
+		t.Fatal("unexpected commits", ahead[0].Hash, behind[0].Hash)
+	}
+	if ahead, more, _ = revList(r, topic, upstream, 1); len(ahead) != 1 || !more {
+		t.Fatal("expected 1 of more commits, but got", len(ahead), more)
+	}
+}
+
+func TestDivergence(t *testing.T) {
+	tr := newTestRepo(t)
+	root := tr.commit("root", nil)
+	// shared is dated after its descendants, so it is walked before
+	// it is found reachable from b
+	tr.when = tr.when.Add(50 * time.Hour)
+	shared := tr.commit("shared", nil, root)
+	tr.when = tr.when.Add(-50 * time.Hour)
+	d := tr.commit("d", nil, shared)
+	b := tr.commit("b", nil, d)
+	a := tr.commit("a", nil, shared)
+
+	onlyA, onlyB, err := divergence(tr.r, a, b)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if !slices.Equal(onlyA, []plumbing.Hash{a}) || !slices.Equal(onlyB, []plumbing.Hash{b, d}) {
+		t.Fatal("expected a and b, d, but got", onlyA, onlyB)
+	}
+	if onlyA, onlyB, err = divergence(tr.r, a, a); err != nil || len(onlyA)+len(onlyB) > 0 {
+		t.Fatal("expected no divergence from itself, but got", onlyA, onlyB, err)
+	}
+
+	// Commits made in the same second are walked in no particular
+	// order
+	tr = newTestRepo(t)
+	commit := func(msg string, parents ...plumbing.Hash) plumbing.Hash {
+		tr.when = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		return tr.commit(msg, nil, parents...)
+	}
+	root = commit("root")
+	feature := commit("feature 2", commit("feature 1", root))
+	merge := commit("merge", commit("main", root), feature)
+	head := commit("head 3", commit("head 2", commit("head 1", merge)))
+	if onlyA, onlyB, err = divergence(tr.r, feature, head); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if len(onlyA) != 0 || len(onlyB) != 5 {
+		t.Fatal("expected 0 ahead and 5 behind, but got", len(onlyA), len(onlyB))
+	}
+}
+
+func TestWritePatchLimits(t *testing.T) {
+	tr := newTestRepo(t)
+	base := tr.commit("base", map[string]string{"a": "a\n", "b": "b\n"})
+	tr.commit("one", map[string]string{"a": "one\n"})
+	tr.commit("two", map[string]string{"a": "two\n", "b": "two\n"})
+	rp := &repo.Repo{R: tr.r}
+
+	for _, tc := range []struct {
+		name string
+		req  *request.Request
+		cfg  config.Config
+		err  bool
+	}{
+		{"range", &request.Request{DiffFrom: base.String(), DiffTo: "HEAD"}, config.Config{}, false},
+		{"commits", &request.Request{DiffFrom: base.String(), DiffTo: "HEAD"}, config.Config{MaxPatchCommits: 1}, true},
+		{"files", &request.Request{Revision: "HEAD"}, config.Config{MaxDiffFiles: 1}, true},
+		{"series files", &request.Request{DiffFrom: base.String(), DiffTo: "HEAD"}, config.Config{MaxDiffFiles: 1}, true},
+		{"lines", &request.Request{Revision: "HEAD"}, config.Config{MaxDiffLines: 1}, true},
+		{"size", &request.Request{Revision: "HEAD"}, config.Config{MaxBlobSize: 1}, true},
+	} {
+		buf := new(bytes.Buffer)
+		err := WritePatch(buf, rp, tc.req, tc.cfg)
+		if !tc.err {
+			if err != nil || strings.Count(buf.String(), "\nFrom: ") != 2 {
+				t.Fatalf("%s: expected 2 patches, but got %v\n%s", tc.name, err, buf)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrDiffTooLarge) || buf.Len() > 0 {
+			t.Fatalf("%s: expected diff too large before writing, but got %v\n%s", tc.name, err, buf)
+		}
+	}
+}
+
+func TestWriteDiffIgnoreWhitespace(t *testing.T) {
+	tr := newTestRepo(t)
+	base := tr.commit("base", map[string]string{"a": "a b\n", "b": "b\n"})
+	tr.commit("space", map[string]string{"a": "a  b\n", "b": "c\n"})
+
+	req := &request.Request{DiffFrom: base.String(), DiffTo: "HEAD", Context: -1}
+	buf := new(bytes.Buffer)
+	if err := WriteDiff(buf, &repo.Repo{R: tr.r}, req, config.Config{}); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if !strings.Contains(buf.String(), "+++ b/a") {
+		t.Fatal("expected a diff of a, but got", buf)
+	}
+	req.IgnoreWhitespace = true
+	buf.Reset()
+	if err := WriteDiff(buf, &repo.Repo{R: tr.r}, req, config.Config{}); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if strings.Contains(buf.String(), "+++ b/a") || !strings.Contains(buf.String(), "+c\n") {
+		t.Fatal("expected a diff of only b, but got", buf)
+	}
+}
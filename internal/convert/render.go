@@ -0,0 +1,212 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"djmo.ch/dgit/config"
+	"djmo.ch/dgit/data"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	nethtml "golang.org/x/net/html"
+	"mvdan.cc/xurls/v2"
+)
+
+// defaultRenderers are used for extensions not found in
+// config.Config.Renderers.
+var defaultRenderers = map[string]config.Renderer{
+	".md":       renderMarkdown,
+	".mkd":      renderMarkdown,
+	".markdown": renderMarkdown,
+}
+
+// lookupRenderer returns the renderer for the file called name, or
+// nil if files with its extension are not rendered.
+func lookupRenderer(cfg config.Config, name string) config.Renderer {
+	ext := path.Ext(name)
+	if ext == "" {
+		return nil
+	}
+	for e, r := range cfg.Renderers {
+		if strings.EqualFold(e, ext) {
+			return r
+		}
+	}
+	return defaultRenderers[strings.ToLower(ext)]
+}
+
+// isReadme returns true if name is README, optionally followed by an
+// extension, without regard to case.
+func isReadme(name string) bool {
+	return strings.EqualFold(strings.TrimSuffix(name, path.Ext(name)), "README")
+}
+
+// readmeRank ranks README file names by preference, lowest first.
+// Files with a renderer are preferred, followed by a plain README and
+// finally any other README.
+func readmeRank(cfg config.Config, name string) int {
+	switch {
+	case lookupRenderer(cfg, name) != nil:
+		return 0
+	case strings.EqualFold(name, "README"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+// markdownPolicy sanitizes rendered Markdown, including any raw HTML
+// it contains. It extends the bluemonday UGC policy with the
+// read-only checkboxes of task lists, and only marks links to other
+// sites nofollow.
+var markdownPolicy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.RequireNoFollowOnLinks(false)
+	p.RequireNoFollowOnFullyQualifiedLinks(true)
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^$`)).OnElements("input")
+	return p
+}()
+
+func renderMarkdown(f config.RenderFile, src []byte) (template.HTML, error) {
+	outBuf := new(bytes.Buffer)
+	markdown := goldmark.New(
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+			parser.WithASTTransformers(
+				util.Prioritized(linkRewriter{f}, 100),
+			),
+		),
+		goldmark.WithRendererOptions(
+			// Raw HTML is sanitized by markdownPolicy below
+			html.WithUnsafe(),
+		),
+		goldmark.WithExtensions(
+			extension.Table,
+			extension.Strikethrough,
+			extension.TaskList,
+			extension.Footnote,
+			extension.NewLinkify(
+				extension.WithLinkifyAllowedProtocols([][]byte{
+					[]byte("http:"),
+					[]byte("https:"),
+					[]byte("mailto:"),
+				}),
+				extension.WithLinkifyURLRegexp(
+					xurls.Strict(),
+				),
+			),
+		),
+	)
+	if err := markdown.Convert(src, outBuf); err != nil {
+		return "", fmt.Errorf("error rendering markdown: %w", err)
+	}
+	return template.HTML(markdownPolicy.SanitizeReader(outBuf).String()), nil
+}
+
+// tableOfContents returns the headings in h that carry an id
+// attribute, in document order.
+func tableOfContents(h template.HTML) []data.Heading {
+	var (
+		toc     = make([]data.Heading, 0)
+		heading *data.Heading
+		text    = new(strings.Builder)
+		z       = nethtml.NewTokenizer(strings.NewReader(string(h)))
+	)
+	for {
+		switch z.Next() {
+		case nethtml.ErrorToken:
+			return toc
+		case nethtml.StartTagToken:
+			tok := z.Token()
+			level := headingLevel(tok.Data)
+			if level == 0 || heading != nil {
+				continue
+			}
+			for _, attr := range tok.Attr {
+				if attr.Key == "id" && attr.Val != "" {
+					heading = &data.Heading{Level: level, ID: attr.Val}
+					text.Reset()
+				}
+			}
+		case nethtml.TextToken:
+			if heading != nil {
+				text.Write(z.Text())
+			}
+		case nethtml.EndTagToken:
+			tok := z.Token()
+			if heading != nil && headingLevel(tok.Data) == heading.Level {
+				heading.Text = strings.Join(strings.Fields(text.String()), " ")
+				toc = append(toc, *heading)
+				heading = nil
+			}
+		}
+	}
+}
+
+// headingLevel returns the level of the heading element named tag,
+// or zero if tag is not a heading.
+func headingLevel(tag string) int {
+	if len(tag) == 2 && tag[0] == 'h' && tag[1] >= '1' && tag[1] <= '6' {
+		return int(tag[1] - '0')
+	}
+	return 0
+}
+
+// linkRewriter is a goldmark AST transformer that rewrites relative
+// link destinations to blob URL's, and relative image sources to raw
+// URL's, at the revision of the rendered file and relative to its
+// directory.
+type linkRewriter struct {
+	f config.RenderFile
+}
+
+// Transform implements the [parser.ASTTransformer] interface.
+func (l linkRewriter) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Link:
+			n.Destination = l.rewrite(n.Destination, "blob")
+		case *ast.Image:
+			n.Destination = l.rewrite(n.Destination, "raw")
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// rewrite returns dest rewritten to point into section if dest is a
+// relative URL. Links to directories, ending in a slash, point into
+// the tree section instead. Other URL's, including those escaping
+// the repository root, are returned unchanged.
+func (l linkRewriter) rewrite(dest []byte, section string) []byte {
+	u, err := url.Parse(string(dest))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" ||
+		strings.HasPrefix(u.Path, "/") || l.f.Revision == "" {
+		return dest
+	}
+	p := path.Join(path.Dir(l.f.Path), u.Path)
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return dest
+	}
+	if strings.HasSuffix(u.Path, "/") {
+		section = "tree"
+	}
+	u.Path = path.Join("/", l.f.Repo, "-", section, l.f.Revision, p)
+	return []byte(u.String())
+}
@@ -0,0 +1,113 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"html/template"
+	"strings"
+	"testing"
+
+	"djmo.ch/dgit/config"
+	"djmo.ch/dgit/data"
+)
+
+func TestLookupRenderer(t *testing.T) {
+	rst := func(f config.RenderFile, src []byte) (template.HTML, error) {
+		return "rst", nil
+	}
+	cfg := config.Config{
+		Renderers: map[string]config.Renderer{
+			".rst": rst,
+			".MKD": nil,
+		},
+	}
+	if lookupRenderer(cfg, "README.md") == nil {
+		t.Error("expected default renderer for README.md")
+	}
+	if lookupRenderer(cfg, "docs/index.RST") == nil {
+		t.Error("expected configured renderer for docs/index.RST")
+	}
+	if lookupRenderer(cfg, "notes.mkd") != nil {
+		t.Error("expected no renderer for disabled extension .mkd")
+	}
+	if lookupRenderer(cfg, "README") != nil {
+		t.Error("expected no renderer for README")
+	}
+}
+
+func TestReadmeRank(t *testing.T) {
+	cfg := config.Config{}
+	for _, name := range []string{"README", "readme.md", "README.rst"} {
+		if !isReadme(name) {
+			t.Errorf("expected %s to be a README", name)
+		}
+	}
+	if isReadme("README-dev.md") {
+		t.Error("expected README-dev.md not to be a README")
+	}
+	if readmeRank(cfg, "README.md") >= readmeRank(cfg, "README") {
+		t.Error("expected README.md to be preferred over README")
+	}
+	if readmeRank(cfg, "README") >= readmeRank(cfg, "README.rst") {
+		t.Error("expected README to be preferred over README.rst")
+	}
+}
+
+func TestRenderMarkdownLinks(t *testing.T) {
+	f := config.RenderFile{Repo: "dgit", Revision: "main", Path: "docs/README.md"}
+	src := "[setup](setup.md#install) [up](../LICENSE) [dir](img/) " +
+		"[web](https://example.com/x) [top](/abs) [anchor](#usage) " +
+		"[out](../../x) ![logo](img/logo%20blue.png)"
+	html, err := renderMarkdown(f, []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, exp := range []string{
+		`<a href="/dgit/-/blob/main/docs/setup.md#install">`,
+		`<a href="/dgit/-/blob/main/LICENSE">`,
+		`<a href="/dgit/-/tree/main/docs/img">`,
+		`<a href="https://example.com/x" rel="nofollow">`,
+		`<a href="/abs">`,
+		`<a href="#usage">`,
+		`<a href="../../x">`,
+		`<img src="/dgit/-/raw/main/docs/img/logo%20blue.png" alt="logo">`,
+	} {
+		if !strings.Contains(string(html), exp) {
+			t.Errorf("expected %s in %s", exp, html)
+		}
+	}
+}
+
+func TestRenderMarkdownGFM(t *testing.T) {
+	src := "# Title\n\n## Usage\n\n| a |\n|---|\n| 1 |\n\n- [x] done\n\n" +
+		"~~old~~ text[^1]\n\n[^1]: note\n\n## Usage\n\n" +
+		"<details><summary>More</summary><script>alert(1)</script></details>\n"
+	html, err := renderMarkdown(config.RenderFile{}, []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, exp := range []string{
+		`<h2 id="usage">Usage</h2>`,
+		`<h2 id="usage-1">Usage</h2>`,
+		`<table>`,
+		`<input checked="" disabled="" type="checkbox">`,
+		`<del>old</del>`,
+		`<a href="#fn:1"`,
+		`<details><summary>More</summary></details>`,
+	} {
+		if !strings.Contains(string(html), exp) {
+			t.Errorf("expected %s in %s", exp, html)
+		}
+	}
+	if strings.Contains(string(html), "<script>") {
+		t.Errorf("expected script to be removed from %s", html)
+	}
+
+	toc := tableOfContents(html)
+	if len(toc) != 3 {
+		t.Fatalf("expected 3 headings, but got %d", len(toc))
+	}
+	if toc[1] != (data.Heading{Level: 2, ID: "usage", Text: "Usage"}) {
+		t.Errorf("unexpected heading: %+v", toc[1])
+	}
+}
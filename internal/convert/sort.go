@@ -3,6 +3,7 @@
 package convert
 
 import (
+	"sort"
 	"time"
 
 	"djmo.ch/dgit/data"
@@ -17,3 +18,38 @@ func (b ByAge) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
 func (b ByAge) Less(i, j int) bool {
 	return time.Time(b[i].Time).Unix() < time.Time(b[j].Time).Unix()
 }
+
+// ByName sorts references by name.
+type ByName []data.Reference
+
+func (b ByName) Len() int { return len(b) }
+
+func (b ByName) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
+func (b ByName) Less(i, j int) bool { return b[i].Name < b[j].Name }
+
+// ByVersion sorts references by the version numbers in their names,
+// such as v1.2.3-rc.1, with names that are not versions first.
+type ByVersion []data.Reference
+
+func (b ByVersion) Len() int { return len(b) }
+
+func (b ByVersion) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
+func (b ByVersion) Less(i, j int) bool {
+	return compareVersions(b[i].Name, b[j].Name) < 0
+}
+
+// sortRefs sorts refs in the given order: newest first by "date" or
+// by default, in order of name by "name", or highest version first by
+// "version". References that are otherwise equal are sorted by name.
+func sortRefs(refs []data.Reference, order string) {
+	sort.Sort(ByName(refs))
+	switch order {
+	case "name":
+	case "version":
+		sort.Stable(sort.Reverse(ByVersion(refs)))
+	default:
+		sort.Stable(sort.Reverse(ByAge(refs)))
+	}
+}
@@ -0,0 +1,91 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// testRepo is a repository with a worktree in a temporary directory,
+// to which tests commit.
+type testRepo struct {
+	t    *testing.T
+	r    *git.Repository
+	wt   *git.Worktree
+	dir  string
+	when time.Time
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+	dir := t.TempDir()
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testRepo{
+		t:    t,
+		r:    r,
+		wt:   wt,
+		dir:  dir,
+		when: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// sig returns the signature of the next commit, an hour after the
+// last.
+func (tr *testRepo) sig() *object.Signature {
+	tr.when = tr.when.Add(time.Hour)
+	return &object.Signature{Name: "Test", Email: "test@example.com", When: tr.when}
+}
+
+// commit writes files to the worktree and commits every change in it
+// with the given message. The commit's parents are parents if given,
+// and otherwise HEAD.
+func (tr *testRepo) commit(msg string, files map[string]string, parents ...plumbing.Hash) plumbing.Hash {
+	tr.t.Helper()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tr.dir, name), []byte(content), 0666); err != nil {
+			tr.t.Fatal(err)
+		}
+	}
+	if err := tr.wt.AddWithOptions(&git.AddOptions{All: true}); err != nil {
+		tr.t.Fatal(err)
+	}
+	sig := tr.sig()
+	hash, err := tr.wt.Commit(msg, &git.CommitOptions{
+		Author:            sig,
+		Committer:         sig,
+		Parents:           parents,
+		AllowEmptyCommits: true,
+	})
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	return hash
+}
+
+// tree returns the tree of the commit with the given hash.
+func (tr *testRepo) tree(hash plumbing.Hash) *object.Tree {
+	tr.t.Helper()
+	c, err := tr.r.CommitObject(hash)
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	return tree
+}
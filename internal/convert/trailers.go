@@ -0,0 +1,58 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"strings"
+
+	"djmo.ch/dgit/data"
+)
+
+// splitTrailers splits message into its body and the trailers in its
+// final paragraph, as recognized by git interpret-trailers. The final
+// paragraph holds trailers only if it follows the subject and each of
+// its lines is either a "Key: value" trailer or, beginning with
+// whitespace, the continuation of one. Otherwise body is the trimmed
+// message and trailers is nil.
+func splitTrailers(message string) (body string, trailers []data.Trailer) {
+	message = strings.TrimRight(message, " \t\n")
+	i := strings.LastIndex(message, "\n\n")
+	if i < 0 {
+		return message, nil
+	}
+	for _, line := range strings.Split(message[i+2:], "\n") {
+		if strings.TrimSpace(line) == "" {
+			return message, nil
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(trailers) == 0 {
+				return message, nil
+			}
+			t := &trailers[len(trailers)-1]
+			t.Value += " " + strings.TrimSpace(line)
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || !isTrailerKey(key) {
+			return message, nil
+		}
+		trailers = append(trailers, data.Trailer{Key: key, Value: strings.TrimSpace(value)})
+	}
+	return strings.TrimRight(message[:i], " \t\n"), trailers
+}
+
+// isTrailerKey returns true if key is a non-empty run of letters,
+// digits and hyphens.
+func isTrailerKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"reflect"
+	"testing"
+
+	"djmo.ch/dgit/data"
+)
+
+func TestSplitTrailers(t *testing.T) {
+	for _, tc := range []struct {
+		message  string
+		body     string
+		trailers []data.Trailer
+	}{
+		{
+			message: "Fix bug\n\nIt was broken.\n\nSigned-off-by: Alice <alice@example.com>\n" +
+				"Reviewed-by: Bob <bob@example.com>\nFixes: 1234567 (\"Break\n  everything\")\n",
+			body: "Fix bug\n\nIt was broken.",
+			trailers: []data.Trailer{
+				{Key: "Signed-off-by", Value: "Alice <alice@example.com>"},
+				{Key: "Reviewed-by", Value: "Bob <bob@example.com>"},
+				{Key: "Fixes", Value: "1234567 (\"Break everything\")"},
+			},
+		},
+		{
+			message: "Subject: only a subject\n",
+			body:    "Subject: only a subject",
+		},
+		{
+			message: "Add feature\n\nSee the docs: they explain it.\nChange-Id: I123\n",
+			body:    "Add feature\n\nSee the docs: they explain it.\nChange-Id: I123",
+		},
+		{
+			message: "Add feature\n\n  indented: not a trailer\n",
+			body:    "Add feature\n\n  indented: not a trailer",
+		},
+		{
+			message:  "Add feature\n\nChange-Id: I123\n\n",
+			body:     "Add feature",
+			trailers: []data.Trailer{{Key: "Change-Id", Value: "I123"}},
+		},
+	} {
+		body, trailers := splitTrailers(tc.message)
+		if body != tc.body {
+			t.Errorf("body: exp=%q, act=%q", tc.body, body)
+		}
+		if !reflect.DeepEqual(trailers, tc.trailers) {
+			t.Errorf("trailers: exp=%v, act=%v", tc.trailers, trailers)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"cmp"
+	"strconv"
+	"strings"
+)
+
+// version is a version number parsed from a tag name.
+type version struct {
+	// The dot-separated numbers before any pre-release
+	core []int
+	// The dot-separated pre-release identifiers, if any
+	pre []string
+}
+
+// parseVersion parses a version number, such as 1.2.3 or v1.2.3-rc.1,
+// as described by [Semantic Versioning]. A leading v is ignored, as is
+// build metadata following a +. Any number of core version numbers is
+// accepted.
+//
+// [Semantic Versioning]: https://semver.org/spec/v2.0.0.html
+func parseVersion(s string) (version, bool) {
+	var v version
+	if len(s) > 0 && (s[0] == 'v' || s[0] == 'V') {
+		s = s[1:]
+	}
+	s, _, _ = strings.Cut(s, "+")
+	core, pre, hasPre := strings.Cut(s, "-")
+	for _, p := range strings.Split(core, ".") {
+		if !isDigits(p) {
+			return version{}, false
+		}
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return version{}, false
+		}
+		v.core = append(v.core, n)
+	}
+	if hasPre {
+		v.pre = strings.Split(pre, ".")
+		for _, id := range v.pre {
+			if id == "" {
+				return version{}, false
+			}
+		}
+	}
+	return v, true
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// compareVersions compares the versions in the names a and b, as
+// parsed by [parseVersion], returning -1, 0 or +1 as a precedes, is
+// equal to or follows b. Missing core version numbers count as zero,
+// so that 1.2 and 1.2.0 are equal. A version with a pre-release
+// precedes the same version without one, and pre-releases are ordered
+// by their identifiers, as by Semantic Versioning. Names that are not
+// versions precede all versions, and are equal to one another.
+func compareVersions(a, b string) int {
+	va, okA := parseVersion(a)
+	vb, okB := parseVersion(b)
+	if !okA || !okB {
+		return compareBool(okA, okB)
+	}
+	for i := 0; i < max(len(va.core), len(vb.core)); i++ {
+		var x, y int
+		if i < len(va.core) {
+			x = va.core[i]
+		}
+		if i < len(vb.core) {
+			y = vb.core[i]
+		}
+		if c := cmp.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	if va.pre == nil || vb.pre == nil {
+		return compareBool(va.pre == nil, vb.pre == nil)
+	}
+	for i := 0; i < min(len(va.pre), len(vb.pre)); i++ {
+		if c := comparePreRelease(va.pre[i], vb.pre[i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(va.pre), len(vb.pre))
+}
+
+// comparePreRelease compares pre-release identifiers. Numeric
+// identifiers are compared numerically, and precede alphanumeric
+// identifiers, which are compared lexically.
+func comparePreRelease(a, b string) int {
+	numA, numB := isDigits(a), isDigits(b)
+	switch {
+	case numA && numB:
+		if c := cmp.Compare(len(strings.TrimLeft(a, "0")), len(strings.TrimLeft(b, "0"))); c != 0 {
+			return c
+		}
+		return strings.Compare(strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0"))
+	case numA || numB:
+		return compareBool(numB, numA)
+	}
+	return strings.Compare(a, b)
+}
+
+// compareBool orders false before true.
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+	return -1
+}
@@ -0,0 +1,72 @@
+// See LICENSE file for copyright and license details
+
+package convert
+
+import (
+	"slices"
+	"testing"
+
+	"djmo.ch/dgit/data"
+)
+
+func TestCompareVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		exp  int
+	}{
+		{"v1.2.3", "v1.2.3", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.2", "1.2.0", 0},
+		{"v1.2.3+build.5", "v1.2.3", 0},
+		{"v1.2.3", "v1.10.0", -1},
+		{"v2", "v1.99.99", 1},
+		{"v1.0.0-rc.1", "v1.0.0", -1},
+		{"v1.0.0-alpha", "v1.0.0-alpha.1", -1},
+		{"v1.0.0-alpha.1", "v1.0.0-alpha.beta", -1},
+		{"v1.0.0-alpha.beta", "v1.0.0-beta", -1},
+		{"v1.0.0-beta.2", "v1.0.0-beta.11", -1},
+		{"v1.0.0-beta.11", "v1.0.0-rc.1", -1},
+		{"release", "v0.0.1", -1},
+		{"release", "snapshot", 0},
+		{"v1..2", "v0.1", -1},
+		{"v1.0.0-", "v0.1", -1},
+	} {
+		if act := compareVersions(tc.a, tc.b); act != tc.exp {
+			t.Errorf("compareVersions(%q, %q): exp=%d, act=%d", tc.a, tc.b, tc.exp, act)
+		}
+		if act := compareVersions(tc.b, tc.a); act != -tc.exp {
+			t.Errorf("compareVersions(%q, %q): exp=%d, act=%d", tc.b, tc.a, -tc.exp, act)
+		}
+	}
+}
+
+func TestSortRefs(t *testing.T) {
+	refs := func(names ...string) []data.Reference {
+		r := make([]data.Reference, len(names))
+		for i, name := range names {
+			r[i].Name = name
+		}
+		return r
+	}
+	names := func(refs []data.Reference) []string {
+		n := make([]string, len(refs))
+		for i, r := range refs {
+			n[i] = r.Name
+		}
+		return n
+	}
+	for _, tc := range []struct {
+		order string
+		exp   []string
+	}{
+		{"date", []string{"latest", "v1.0.0", "v1.1.0-rc.1", "v1.10.0", "v1.2.0"}},
+		{"name", []string{"latest", "v1.0.0", "v1.1.0-rc.1", "v1.10.0", "v1.2.0"}},
+		{"version", []string{"v1.10.0", "v1.2.0", "v1.1.0-rc.1", "v1.0.0", "latest"}},
+	} {
+		r := refs("v1.2.0", "v1.0.0", "latest", "v1.10.0", "v1.1.0-rc.1")
+		sortRefs(r, tc.order)
+		if act := names(r); !slices.Equal(act, tc.exp) {
+			t.Errorf("%s: exp=%v, act=%v", tc.order, tc.exp, act)
+		}
+	}
+}
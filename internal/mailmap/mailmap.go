@@ -0,0 +1,114 @@
+// See LICENSE file for copyright and license details
+
+// Package mailmap implements the mapping of author and committer
+// identities to canonical names and emails, as described in
+// gitmailmap(5).
+package mailmap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Mailmap maps the names and emails recorded in commits to canonical
+// ones. The zero value and a nil *Mailmap map every identity to
+// itself.
+type Mailmap struct {
+	// entries maps lower-case commit emails to the entries for
+	// them, keyed by lower-case commit name. The entry for any name
+	// is keyed by the empty string.
+	entries map[string]map[string]entry
+}
+
+// entry is the canonical identity for a commit identity. Either field
+// may be empty, in which case that part of the identity is unchanged.
+type entry struct {
+	name, email string
+}
+
+// Parse reads mailmap entries from r and adds them to m. Entries read
+// later take precedence over earlier entries for the same identity.
+// Malformed lines are ignored, as by git.
+func (m *Mailmap) Parse(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		m.parseLine(s.Text())
+	}
+	if err := s.Err(); err != nil {
+		return fmt.Errorf("error reading mailmap: %w", err)
+	}
+	return nil
+}
+
+// parseLine adds the entry on line, one of:
+//
+//	Proper Name <commit@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+func (m *Mailmap) parseLine(line string) {
+	if strings.HasPrefix(strings.TrimSpace(line), "#") {
+		return
+	}
+	name1, email1, rest, ok := parseIdent(line)
+	if !ok {
+		return
+	}
+	var (
+		e           = entry{name: name1}
+		commitName  string
+		commitEmail = email1
+	)
+	if name2, email2, _, ok := parseIdent(rest); ok {
+		e.email, commitName, commitEmail = email1, name2, email2
+	}
+	if e.name == "" && e.email == "" {
+		return
+	}
+	key := strings.ToLower(commitEmail)
+	if m.entries == nil {
+		m.entries = make(map[string]map[string]entry)
+	}
+	if m.entries[key] == nil {
+		m.entries[key] = make(map[string]entry)
+	}
+	m.entries[key][strings.ToLower(commitName)] = e
+}
+
+// parseIdent parses the name and bracketed email at the start of s,
+// returning the remainder of s following the email.
+func parseIdent(s string) (name, email, rest string, ok bool) {
+	name, s, ok = strings.Cut(s, "<")
+	if !ok {
+		return "", "", "", false
+	}
+	email, rest, ok = strings.Cut(s, ">")
+	if !ok {
+		return "", "", "", false
+	}
+	return strings.TrimSpace(name), strings.TrimSpace(email), rest, true
+}
+
+// Map returns the canonical name and email for the given commit name
+// and email. An entry for both the name and email is preferred to one
+// for the email alone. Emails and names are matched without regard to
+// case.
+func (m *Mailmap) Map(name, email string) (string, string) {
+	if m == nil {
+		return name, email
+	}
+	names := m.entries[strings.ToLower(email)]
+	e, ok := names[strings.ToLower(name)]
+	if !ok {
+		e = names[""]
+	}
+	if e.name != "" {
+		name = e.name
+	}
+	if e.email != "" {
+		email = e.email
+	}
+	return name, email
+}
@@ -0,0 +1,67 @@
+// See LICENSE file for copyright and license details
+
+package mailmap
+
+import (
+	"strings"
+	"testing"
+)
+
+const testMailmap = `# Comment
+Alice Doe <alice@example.com>
+<bob@example.com> <bob@old.example.com>
+Carol Roe <carol@example.com> <CAROL@laptop.local>
+Dave Poe <dave@example.com> dave <shared@example.com>
+Erin Moe <erin@example.com> erin <shared@example.com> # trailing comment
+Alice Doe <alice@example.com> Alice <alice@old.example.com>
+bad line without email
+`
+
+func TestMap(t *testing.T) {
+	m := new(Mailmap)
+	if err := m.Parse(strings.NewReader(testMailmap)); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	for _, tc := range []struct {
+		name, email       string
+		expName, expEmail string
+	}{
+		{"alice", "alice@example.com", "Alice Doe", "alice@example.com"},
+		{"Bob", "bob@old.example.com", "Bob", "bob@example.com"},
+		{"carol", "carol@Laptop.Local", "Carol Roe", "carol@example.com"},
+		{"Dave", "shared@example.com", "Dave Poe", "dave@example.com"},
+		{"erin", "shared@example.com", "Erin Moe", "erin@example.com"},
+		{"frank", "shared@example.com", "frank", "shared@example.com"},
+		{"alice", "alice@old.example.com", "Alice Doe", "alice@example.com"},
+		{"Alicia", "alice@old.example.com", "Alicia", "alice@old.example.com"},
+		{"Grace", "grace@example.com", "Grace", "grace@example.com"},
+	} {
+		name, email := m.Map(tc.name, tc.email)
+		if name != tc.expName || email != tc.expEmail {
+			t.Errorf("%s <%s>: exp=%s <%s>, act=%s <%s>", tc.name, tc.email,
+				tc.expName, tc.expEmail, name, email)
+		}
+	}
+}
+
+func TestMapOverride(t *testing.T) {
+	m := new(Mailmap)
+	for _, src := range []string{
+		"Old Name <alice@example.com>\n",
+		"New Name <alice@example.com>\n",
+	} {
+		if err := m.Parse(strings.NewReader(src)); err != nil {
+			t.Fatal("unexpected error", err)
+		}
+	}
+	if name, _ := m.Map("alice", "alice@example.com"); name != "New Name" {
+		t.Fatal("expected later entry to take precedence, but got", name)
+	}
+}
+
+func TestMapNil(t *testing.T) {
+	var m *Mailmap
+	if name, email := m.Map("alice", "alice@example.com"); name != "alice" || email != "alice@example.com" {
+		t.Fatal("expected identity unchanged, but got", name, email)
+	}
+}
@@ -11,10 +11,13 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"djmo.ch/dgit/config"
+	"djmo.ch/dgit/data"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 )
 
 const lastModifiedFormat = "2006-01-02 15:04:05 -0700"
@@ -32,11 +35,24 @@ type Repo struct {
 	// Description is the repository description as read from the
 	// gitweb.description Git config key.
 	Description string
+	// Category is the repository category as read from the
+	// gitweb.category Git config key.
+	Category string
+	// Options contains the options in the dgit section of the
+	// repository's Git config, keyed by lower-case option name.
+	// Options within a subsection are keyed as
+	// "subsection.option".
+	Options map[string]string
 	// LastModified records the timestamp of the most recent
 	// commit as read from info/web/last-modified within the
-	// repository's Git directory. See [CGit] for prior art.
+	// repository's Git directory. See [CGit] for prior art. When
+	// that file does not exist, it is the newest committer time
+	// across the repository's branch heads.
 	// [CGit]: https://git.zx2c4.com/cgit/tree/contrib/hooks/post-receive.agefile
 	LastModified time.Time
+	// LastModifiedSource records where LastModified was read
+	// from.
+	LastModifiedSource data.LastModifiedSource
 
 	// R is the raw repository
 	R *git.Repository
@@ -59,28 +75,144 @@ func NewRepo(path string, cfg config.Config) (*Repo, error) {
 		log.Printf("failed to read config for repo %s: %v", path, err)
 		return nil, fmt.Errorf("failed to read config for repo %s: %v", path, err)
 	}
+	re.Options = make(map[string]string)
 	for _, section := range repoCfg.Raw.Sections {
-		if section.Name == "gitweb" {
+		switch strings.ToLower(section.Name) {
+		case "gitweb":
 			re.Owner = section.Option("owner")
 			re.Description = section.Option("description")
+			re.Category = section.Option("category")
+		case "dgit":
+			for _, opt := range section.Options {
+				re.Options[strings.ToLower(opt.Key)] = opt.Value
+			}
+			for _, sub := range section.Subsections {
+				for _, opt := range sub.Options {
+					re.Options[sub.Name+"."+strings.ToLower(opt.Key)] = opt.Value
+				}
+			}
 		}
 	}
+	re.LastModified, err = readAgeFile(path)
+	switch {
+	case err == nil:
+		re.LastModifiedSource = data.LastModifiedAgeFile
+	case errors.Is(err, fs.ErrNotExist):
+		// Not an error. Fall back to the branch heads.
+		re.LastModified, err = refsLastModified(path, re.R)
+		if err != nil {
+			log.Printf("failed to read last-modified from refs for repo %s: %v", path, err)
+			return nil, fmt.Errorf("failed to read last-modified from refs for repo %s: %v", path, err)
+		}
+		if !re.LastModified.IsZero() {
+			re.LastModifiedSource = data.LastModifiedRefs
+		}
+	default:
+		log.Printf("failed to read last-modified for repo %s: %v", path, err)
+		return nil, fmt.Errorf("failed to read last-modified for repo %s: %v", path, err)
+	}
+	return re, nil
+}
+
+// readAgeFile reads the last-modified time from
+// info/web/last-modified within the Git directory at path.
+func readAgeFile(path string) (time.Time, error) {
 	lastModifiedBytes, err := os.ReadFile(filepath.Join(path, "info", "web", "last-modified"))
 	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
-			// Not an error. The file just doesn't exist.
-			return re, nil
-		}
-		log.Printf("failed to read last-modified file for repo %s: %v", path, err)
-		return nil, fmt.Errorf("failed to read last-modified file for repo %s: %v", path, err)
+		return time.Time{}, err
 	}
 	lastModifiedBytes = bytes.TrimSpace(lastModifiedBytes)
-	re.LastModified, err = time.Parse(lastModifiedFormat, string(lastModifiedBytes))
+	lastModified, err := time.Parse(lastModifiedFormat, string(lastModifiedBytes))
 	if err != nil {
-		log.Printf("failed to parse last-modified for %s: %v", path, err)
-		return nil, fmt.Errorf("failed to parse last-modified for %s: %v", path, err)
+		return time.Time{}, fmt.Errorf("failed to parse last-modified: %v", err)
 	}
-	return re, nil
+	return lastModified, nil
+}
+
+// refsStamp identifies the state of a repository's branch heads by
+// the modification times of its packed-refs file and of the newest
+// entry below refs/heads.
+type refsStamp struct {
+	packed, loose int64
+}
+
+type lastModifiedEntry struct {
+	stamp        refsStamp
+	lastModified time.Time
+}
+
+// lastModifiedCache caches the results of refsLastModified, keyed by
+// Git directory.
+var lastModifiedCache = struct {
+	sync.Mutex
+	entries map[string]lastModifiedEntry
+}{entries: make(map[string]lastModifiedEntry)}
+
+// refsLastModified returns the newest committer time across the
+// branch heads of r, whose Git directory is at path. Results are
+// cached until the repository's refs are modified.
+func refsLastModified(path string, r *git.Repository) (time.Time, error) {
+	stamp, err := readRefsStamp(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	lastModifiedCache.Lock()
+	entry, ok := lastModifiedCache.entries[path]
+	lastModifiedCache.Unlock()
+	if ok && entry.stamp == stamp {
+		return entry.lastModified, nil
+	}
+
+	var lastModified time.Time
+	bIter, err := r.Branches()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error listing branches: %w", err)
+	}
+	defer bIter.Close()
+	if err := bIter.ForEach(func(ref *plumbing.Reference) error {
+		c, err := r.CommitObject(ref.Hash())
+		if err != nil {
+			log.Printf("failed to resolve branch %s in %s: %v", ref.Name(), path, err)
+			return nil
+		}
+		if c.Committer.When.After(lastModified) {
+			lastModified = c.Committer.When
+		}
+		return nil
+	}); err != nil {
+		return time.Time{}, fmt.Errorf("error enumerating branches: %w", err)
+	}
+
+	lastModifiedCache.Lock()
+	lastModifiedCache.entries[path] = lastModifiedEntry{stamp: stamp, lastModified: lastModified}
+	lastModifiedCache.Unlock()
+	return lastModified, nil
+}
+
+func readRefsStamp(path string) (refsStamp, error) {
+	var stamp refsStamp
+	info, err := os.Stat(filepath.Join(path, "packed-refs"))
+	switch {
+	case err == nil:
+		stamp.packed = info.ModTime().UnixNano()
+	case !errors.Is(err, fs.ErrNotExist):
+		return stamp, err
+	}
+	err = filepath.WalkDir(filepath.Join(path, "refs", "heads"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		stamp.loose = max(stamp.loose, info.ModTime().UnixNano())
+		return nil
+	})
+	return stamp, err
 }
 
 // IsRepo returns true of the provided path is the base directory of a
@@ -107,3 +239,26 @@ func (b ByLastModified) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
 func (b ByLastModified) Less(i, j int) bool {
 	return b[i].LastModified.Unix() < b[j].LastModified.Unix()
 }
+
+type ByName []*Repo
+
+func (b ByName) Len() int { return len(b) }
+
+func (b ByName) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
+func (b ByName) Less(i, j int) bool {
+	return strings.ToLower(b[i].Slug) < strings.ToLower(b[j].Slug)
+}
+
+type ByOwner []*Repo
+
+func (b ByOwner) Len() int { return len(b) }
+
+func (b ByOwner) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
+func (b ByOwner) Less(i, j int) bool {
+	if !strings.EqualFold(b[i].Owner, b[j].Owner) {
+		return strings.ToLower(b[i].Owner) < strings.ToLower(b[j].Owner)
+	}
+	return strings.ToLower(b[i].Slug) < strings.ToLower(b[j].Slug)
+}
@@ -0,0 +1,102 @@
+// See LICENSE file for copyright and license details
+
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"djmo.ch/dgit/config"
+	"djmo.ch/dgit/data"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestLastModifiedFromRefs(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		when = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	)
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit(t, r, dir, when)
+
+	re, err := NewRepo(filepath.Join(dir, ".git"), config.Config{RepoBasePath: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if re.LastModifiedSource != data.LastModifiedRefs {
+		t.Errorf("expected source refs, but got %s", re.LastModifiedSource)
+	}
+	if !re.LastModified.Equal(when) {
+		t.Errorf("expected %s, but got %s", when, re.LastModified)
+	}
+
+	later := when.Add(time.Hour)
+	commit(t, r, dir, later)
+	// Ensure the ref's modification time differs from the cached
+	// stamp, even on file systems with coarse timestamps.
+	head := filepath.Join(dir, ".git", "refs", "heads", "master")
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(head, future, future); err != nil {
+		t.Fatal(err)
+	}
+	re, err = NewRepo(filepath.Join(dir, ".git"), config.Config{RepoBasePath: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !re.LastModified.Equal(later) {
+		t.Errorf("expected %s, but got %s", later, re.LastModified)
+	}
+}
+
+func TestLastModifiedFromAgeFile(t *testing.T) {
+	dir := t.TempDir()
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit(t, r, dir, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	webDir := filepath.Join(dir, ".git", "info", "web")
+	if err := os.MkdirAll(webDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(webDir, "last-modified"),
+		[]byte("2024-04-01 08:00:00 +0000\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	re, err := NewRepo(filepath.Join(dir, ".git"), config.Config{RepoBasePath: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if re.LastModifiedSource != data.LastModifiedAgeFile {
+		t.Errorf("expected source agefile, but got %s", re.LastModifiedSource)
+	}
+	if exp := time.Date(2024, 4, 1, 8, 0, 0, 0, time.UTC); !re.LastModified.Equal(exp) {
+		t.Errorf("expected %s, but got %s", exp, re.LastModified)
+	}
+}
+
+func commit(t *testing.T, r *git.Repository, dir string, when time.Time) {
+	t.Helper()
+	wt, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte(when.String()), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add("file"); err != nil {
+		t.Fatal(err)
+	}
+	sig := &object.Signature{Name: "Test", Email: "test@example.com", When: when}
+	if _, err := wt.Commit("commit", &git.CommitOptions{Author: sig, Committer: sig}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -8,7 +8,10 @@ import (
 	"net/url"
 	"path"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"djmo.ch/dgit/data"
 )
@@ -18,7 +21,7 @@ var (
 	ErrUnknownSection = errors.New("request for unknown Section")
 )
 
-const WebSections = "head tree blob raw diff refs log commit"
+const WebSections = "head tree blob raw diff compare patch refs log commit"
 
 type Request struct {
 	Repo             string
@@ -27,6 +30,49 @@ type Request struct {
 	Path             string
 	From             data.Hash
 	DiffFrom, DiffTo string
+
+	// Query, Owner and Category filter the repository index. Query
+	// also filters refs by name.
+	Query, Owner, Category string
+	// Kind is the requested kind of refs, either "branches" or
+	// "tags". It is empty when both were requested.
+	Kind string
+	// Sort is the requested sort order, of the repository index or
+	// of tags.
+	Sort string
+	// Page is the requested page number, starting at 1. It is
+	// zero when no page was requested.
+	Page int
+	// Order is the requested order of a log, either "date" or
+	// "topo". It is empty when no order was requested.
+	Order string
+	// Since and Until are the first and last days, in UTC, of the
+	// commits requested in a log. Each is zero when not requested.
+	Since, Until time.Time
+	// Author filters a log by author name or email.
+	Author string
+
+	// View is the requested diff view, either "unified" or
+	// "split". It is empty when no view was requested.
+	View string
+	// IgnoreWhitespace is true if whitespace changes are to be
+	// ignored in diffs.
+	IgnoreWhitespace bool
+	// Context is the requested number of context lines on either
+	// side of a change in diffs. It is negative when no context
+	// was requested.
+	Context int
+	// Parent is the requested parent, numbered from 1, against
+	// which a commit is diffed. It is zero when no parent was
+	// requested.
+	Parent int
+	// Plain is true if a diff was requested as plain text, by a
+	// .diff suffix on the commit range.
+	Plain bool
+	// MergeBase is true if a three-dot range (rev1...rev2) was
+	// requested, in which case DiffTo is compared with the merge
+	// base of DiffFrom and DiffTo rather than with DiffFrom itself.
+	MergeBase bool
 }
 
 var errInvalidClonePath = errors.New("invalid clone request path")
@@ -100,11 +146,14 @@ func parseCloneRequest(url *url.URL) (*Request, error) {
 }
 
 func parseWebRequest(url *url.URL) (*Request, error) {
-	r := new(Request)
+	r := &Request{Context: -1}
 	splitPath := splitPath(url.Path)
 
 	if len(splitPath) == 0 {
 		r.Section = "repo"
+		if err := parseIndexQuery(r, url.Query()); err != nil {
+			return nil, err
+		}
 		return r, nil
 	}
 	r.Repo = splitPath[0]
@@ -123,12 +172,9 @@ func parseWebRequest(url *url.URL) (*Request, error) {
 		}
 	}
 
-	if len(splitPath) == 0 {
-		r.Section = "head"
-		return r, nil
-	}
-
 	switch len(splitPath) {
+	case 0:
+		r.Section = "head"
 	default:
 		r.Path = strings.Join(splitPath[2:], "/")
 		fallthrough
@@ -139,24 +185,42 @@ func parseWebRequest(url *url.URL) (*Request, error) {
 		r.Section = splitPath[0]
 	}
 
-	if r.Section == "diff" {
-		ids := strings.Split(r.Revision, "..")
-		if len(ids) != 2 {
-			return nil, fmt.Errorf("%w: bad commit range: %s",
-				ErrMalformed, r.Revision)
-		}
-		r.Revision = ""
-		r.DiffFrom = ids[0]
-		r.DiffTo = ids[1]
-		return r, nil
+	q := url.Query()
+	if err := parseDiffQuery(r, q); err != nil {
+		return nil, err
 	}
-
-	r.From = data.Hash(url.Query().Get("from"))
-	if r.From != "" && r.Section != "log" {
-		return nil, fmt.Errorf("%w: 'from' in query not in 'log'", ErrMalformed)
+	if err := parseLogQuery(r, q); err != nil {
+		return nil, err
+	}
+	if err := parseRefsQuery(r, q); err != nil {
+		return nil, err
+	}
+	if err := parsePage(r, q); err != nil {
+		return nil, err
 	}
 
 	switch r.Section {
+	case "patch":
+		if r.Path != "" {
+			return nil, fmt.Errorf("%w: 'Path' specified with '%s'",
+				ErrMalformed, r.Section)
+		}
+		if r.Revision == "" {
+			return nil, fmt.Errorf("%w: no 'Revision' specified with '%s'",
+				ErrMalformed, r.Section)
+		}
+		if strings.Contains(r.Revision, "..") {
+			if err := parseRange(r); err != nil {
+				return nil, err
+			}
+		}
+	case "diff":
+		r.Revision, r.Plain = strings.CutSuffix(r.Revision, ".diff")
+		fallthrough
+	case "compare":
+		if err := parseRange(r); err != nil {
+			return nil, err
+		}
 	case "refs":
 		if r.Revision != "" {
 			return nil, fmt.Errorf("%w: 'Revision' specified with '%s'",
@@ -173,6 +237,152 @@ func parseWebRequest(url *url.URL) (*Request, error) {
 	return r, nil
 }
 
+// parseRange moves the commit range rev1..rev2 or rev1...rev2 in
+// r.Revision to r.DiffFrom and r.DiffTo.
+func parseRange(r *Request) error {
+	sep := ".."
+	if strings.Contains(r.Revision, "...") {
+		sep, r.MergeBase = "...", true
+	}
+	ids := strings.Split(r.Revision, sep)
+	if len(ids) != 2 || ids[0] == "" || ids[1] == "" {
+		return fmt.Errorf("%w: bad commit range: %s",
+			ErrMalformed, r.Revision)
+	}
+	r.Revision = ""
+	r.DiffFrom = ids[0]
+	r.DiffTo = ids[1]
+	return nil
+}
+
+var (
+	indexSorts = []string{"", "age", "name", "owner"}
+	diffViews  = []string{"", "unified", "split"}
+	logOrders  = []string{"", "date", "topo"}
+	refKinds   = []string{"", "branches", "tags"}
+	tagSorts   = []string{"", "date", "name", "version"}
+)
+
+func parseIndexQuery(r *Request, q url.Values) error {
+	r.Query = q.Get("q")
+	r.Owner = q.Get("owner")
+	r.Category = q.Get("category")
+	r.Sort = q.Get("sort")
+	if !slices.Contains(indexSorts, r.Sort) {
+		return fmt.Errorf("%w: unknown sort order: %s", ErrMalformed, r.Sort)
+	}
+	return parsePage(r, q)
+}
+
+func parseLogQuery(r *Request, q url.Values) error {
+	if r.Section != "log" {
+		for _, key := range []string{"from", "order", "since", "until", "author"} {
+			if q.Has(key) {
+				return fmt.Errorf("%w: '%s' in query not in 'log'", ErrMalformed, key)
+			}
+		}
+		return nil
+	}
+	r.From = data.Hash(q.Get("from"))
+	r.Order = q.Get("order")
+	if !slices.Contains(logOrders, r.Order) {
+		return fmt.Errorf("%w: unknown log order: %s", ErrMalformed, r.Order)
+	}
+	for key, t := range map[string]*time.Time{"since": &r.Since, "until": &r.Until} {
+		if q.Get(key) == "" {
+			continue
+		}
+		day, err := time.Parse(time.DateOnly, q.Get(key))
+		if err != nil {
+			return fmt.Errorf("%w: bad date for '%s': %s", ErrMalformed, key, q.Get(key))
+		}
+		*t = day
+	}
+	r.Author = strings.TrimSpace(q.Get("author"))
+	return nil
+}
+
+func parseRefsQuery(r *Request, q url.Values) error {
+	if r.Section != "refs" {
+		for _, key := range []string{"q", "kind", "sort"} {
+			if q.Has(key) {
+				return fmt.Errorf("%w: '%s' in query not in 'refs'", ErrMalformed, key)
+			}
+		}
+		return nil
+	}
+	r.Query = strings.TrimSpace(q.Get("q"))
+	r.Kind = q.Get("kind")
+	if !slices.Contains(refKinds, r.Kind) {
+		return fmt.Errorf("%w: unknown kind of refs: %s", ErrMalformed, r.Kind)
+	}
+	r.Sort = q.Get("sort")
+	if !slices.Contains(tagSorts, r.Sort) {
+		return fmt.Errorf("%w: unknown tag sort order: %s", ErrMalformed, r.Sort)
+	}
+	return nil
+}
+
+func parseDiffQuery(r *Request, q url.Values) error {
+	if r.Section != "commit" && r.Section != "diff" && r.Section != "compare" {
+		for _, key := range []string{"view", "w", "context", "parent"} {
+			if q.Has(key) {
+				return fmt.Errorf("%w: '%s' in query not in 'commit', 'diff' or 'compare'",
+					ErrMalformed, key)
+			}
+		}
+		return nil
+	}
+	r.View = q.Get("view")
+	if !slices.Contains(diffViews, r.View) {
+		return fmt.Errorf("%w: unknown diff view: %s", ErrMalformed, r.View)
+	}
+	if q.Has("w") {
+		w, err := strconv.ParseBool(q.Get("w"))
+		if err != nil {
+			return fmt.Errorf("%w: bad whitespace option: %s", ErrMalformed, q.Get("w"))
+		}
+		r.IgnoreWhitespace = w
+	}
+	if q.Has("context") {
+		context, err := strconv.Atoi(q.Get("context"))
+		if err != nil || context < 0 {
+			return fmt.Errorf("%w: bad context: %s", ErrMalformed, q.Get("context"))
+		}
+		r.Context = context
+	}
+	if q.Has("parent") {
+		if r.Section != "commit" {
+			return fmt.Errorf("%w: 'parent' in query not in 'commit'", ErrMalformed)
+		}
+		parent, err := strconv.Atoi(q.Get("parent"))
+		if err != nil || parent < 1 {
+			return fmt.Errorf("%w: bad parent: %s", ErrMalformed, q.Get("parent"))
+		}
+		r.Parent = parent
+	}
+	return nil
+}
+
+// pagedSections are the sections presented in pages: the index, the
+// log and refs.
+var pagedSections = []string{"repo", "log", "refs"}
+
+func parsePage(r *Request, q url.Values) error {
+	if !q.Has("page") {
+		return nil
+	}
+	if !slices.Contains(pagedSections, r.Section) {
+		return fmt.Errorf("%w: 'page' in query not in the index, 'log' or 'refs'", ErrMalformed)
+	}
+	page, err := strconv.Atoi(q.Get("page"))
+	if err != nil || page < 1 {
+		return fmt.Errorf("%w: bad page: %s", ErrMalformed, q.Get("page"))
+	}
+	r.Page = page
+	return nil
+}
+
 func splitPath(path string) []string {
 	splitPath := strings.Split(path, "/")
 	for len(splitPath) > 0 && splitPath[0] == "" {
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"net/url"
 	"testing"
+	"time"
 )
 
 func TestParse(t *testing.T) {
@@ -179,3 +180,260 @@ func mustParse(rawURL string) *url.URL {
 	}
 	return url
 }
+
+func TestIndexQuery(t *testing.T) {
+	req, err := Parse(mustParse("/?q=dgit&owner=djmoch&category=tools&sort=name&page=2"))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if req.Section != "repo" {
+		t.Fatal("Section: exp=repo, act=", req.Section)
+	}
+	if req.Query != "dgit" || req.Owner != "djmoch" || req.Category != "tools" {
+		t.Fatal("unexpected filters:", req.Query, req.Owner, req.Category)
+	}
+	if req.Sort != "name" {
+		t.Fatal("Sort: exp=name, act=", req.Sort)
+	}
+	if req.Page != 2 {
+		t.Fatal("Page: exp=2, act=", req.Page)
+	}
+}
+
+func TestIndexBadQuery(t *testing.T) {
+	for _, rawURL := range []string{"/?sort=size", "/?page=0", "/?page=two"} {
+		_, err := Parse(mustParse(rawURL))
+		if !errors.Is(err, ErrMalformed) {
+			t.Fatal("expected malformed request for", rawURL)
+		}
+	}
+}
+
+func TestDiffView(t *testing.T) {
+	req, err := Parse(mustParse("/testRepo/-/commit/main?view=split"))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if req.View != "split" {
+		t.Fatal("View: exp=split, act=", req.View)
+	}
+	for _, rawURL := range []string{"/testRepo/-/commit/main?view=wide", "/testRepo/-/tree/main?view=split"} {
+		_, err := Parse(mustParse(rawURL))
+		if !errors.Is(err, ErrMalformed) {
+			t.Fatal("expected malformed request for", rawURL)
+		}
+	}
+}
+
+func TestDiffOptions(t *testing.T) {
+	req, err := Parse(mustParse("/testRepo/-/diff/a..b?w=1&context=0"))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if !req.IgnoreWhitespace || req.Context != 0 {
+		t.Fatal("expected whitespace ignored with no context, but got", req.IgnoreWhitespace, req.Context)
+	}
+	req, err = Parse(mustParse("/testRepo/-/commit/main?parent=2"))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if req.Parent != 2 {
+		t.Fatal("Parent: exp=2, act=", req.Parent)
+	}
+	req, err = Parse(mustParse("/testRepo/-/commit/main"))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if req.IgnoreWhitespace || req.Context >= 0 || req.Parent != 0 {
+		t.Fatal("expected default diff options, but got", req.IgnoreWhitespace, req.Context)
+	}
+	for _, rawURL := range []string{
+		"/testRepo/-/commit/main?w=yes",
+		"/testRepo/-/commit/main?context=-1",
+		"/testRepo/-/log/main?context=5",
+		"/testRepo/-/commit/main?parent=0",
+		"/testRepo/-/diff/a..b?parent=1",
+	} {
+		_, err := Parse(mustParse(rawURL))
+		if !errors.Is(err, ErrMalformed) {
+			t.Fatal("expected malformed request for", rawURL)
+		}
+	}
+}
+
+func TestPatch(t *testing.T) {
+	req, err := Parse(mustParse("/testRepo/-/patch/a..b"))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if req.Revision != "" || req.DiffFrom != "a" || req.DiffTo != "b" {
+		t.Fatal("expected range a..b, but got", req.Revision, req.DiffFrom, req.DiffTo)
+	}
+	req, err = Parse(mustParse("/testRepo/-/patch/main"))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if req.Revision != "main" {
+		t.Fatal("Revision: exp=main, act=", req.Revision)
+	}
+	req, err = Parse(mustParse("/testRepo/-/diff/a..b.diff"))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if !req.Plain || req.DiffFrom != "a" || req.DiffTo != "b" {
+		t.Fatal("expected plain diff from a to b, but got", req.Plain, req.DiffFrom, req.DiffTo)
+	}
+	_, err = Parse(mustParse("/testRepo/-/patch/main/README.md"))
+	if !errors.Is(err, ErrMalformed) {
+		t.Fatal("expected malformed request for patch with path")
+	}
+	_, err = Parse(mustParse("/testRepo/-/patch"))
+	if !errors.Is(err, ErrMalformed) {
+		t.Fatal("expected malformed request for patch without revision")
+	}
+}
+
+func TestMergeBaseRange(t *testing.T) {
+	for _, rawURL := range []string{
+		"/testRepo/-/diff/main...topic",
+		"/testRepo/-/compare/main...topic",
+		"/testRepo/-/patch/main...topic",
+	} {
+		req, err := Parse(mustParse(rawURL))
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+		if !req.MergeBase || req.DiffFrom != "main" || req.DiffTo != "topic" {
+			t.Fatal("expected merge base range for", rawURL, "but got", req.MergeBase, req.DiffFrom, req.DiffTo)
+		}
+	}
+	req, err := Parse(mustParse("/testRepo/-/compare/main..topic?view=split"))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if req.MergeBase || req.View != "split" {
+		t.Fatal("expected two-dot range with split view, but got", req.MergeBase, req.View)
+	}
+	for _, rawURL := range []string{
+		"/testRepo/-/compare/main",
+		"/testRepo/-/compare/main...",
+		"/testRepo/-/diff/..topic",
+	} {
+		_, err := Parse(mustParse(rawURL))
+		if !errors.Is(err, ErrMalformed) {
+			t.Fatal("expected malformed request for", rawURL)
+		}
+	}
+}
+
+func TestLogOrder(t *testing.T) {
+	req, err := Parse(mustParse("/testRepo/-/log/main?order=topo"))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if req.Order != "topo" {
+		t.Fatal("Order: exp=topo, act=", req.Order)
+	}
+	for _, rawURL := range []string{
+		"/testRepo/-/log/main?order=random",
+		"/testRepo/-/tree/main?order=topo",
+	} {
+		_, err := Parse(mustParse(rawURL))
+		if !errors.Is(err, ErrMalformed) {
+			t.Fatal("expected malformed request for", rawURL)
+		}
+	}
+}
+
+func TestLogPage(t *testing.T) {
+	req, err := Parse(mustParse("/testRepo/-/log/main?from=abc&page=3"))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if req.From != "abc" || req.Page != 3 {
+		t.Fatal("expected page 3 from abc, but got", req.From, req.Page)
+	}
+	if req, err = Parse(mustParse("/?page=2")); err != nil || req.Page != 2 {
+		t.Fatal("expected index page 2, but got", req, err)
+	}
+	for _, rawURL := range []string{
+		"/testRepo/-/log/main?page=0",
+		"/testRepo/-/tree/main?page=2",
+		"/testRepo?page=2",
+		"/testRepo/-/patch/main?page=2",
+		"/testRepo/-/diff/a..b?page=2",
+		"/testRepo/-/compare/a..b?page=2",
+	} {
+		_, err := Parse(mustParse(rawURL))
+		if !errors.Is(err, ErrMalformed) {
+			t.Fatal("expected malformed request for", rawURL)
+		}
+	}
+}
+
+func TestRefsQuery(t *testing.T) {
+	req, err := Parse(mustParse("/testRepo/-/refs?q=+feat+&kind=branches&page=2"))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if req.Query != "feat" || req.Kind != "branches" || req.Page != 2 {
+		t.Fatal("expected page 2 of branches matching feat, but got", req.Query, req.Kind, req.Page)
+	}
+	if req, err = Parse(mustParse("/testRepo/-/refs?sort=version")); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if req.Sort != "version" {
+		t.Fatal("expected version sort, but got", req.Sort)
+	}
+	for _, rawURL := range []string{
+		"/testRepo/-/refs?kind=remotes",
+		"/testRepo/-/refs?sort=age",
+		"/testRepo/-/tree/main?sort=name",
+		"/testRepo/-/refs?page=0",
+		"/testRepo/-/refs?from=abc",
+		"/testRepo/-/log/main?kind=tags",
+		"/testRepo/-/tree/main?q=feat",
+		"/testRepo/-/patch/a..b?from=abc",
+		"/testRepo/-/diff/a..b.diff?sort=name",
+		"/testRepo/-/compare/a...b?q=feat",
+		"/testRepo/-/compare/a...b?author=alice",
+	} {
+		_, err := Parse(mustParse(rawURL))
+		if !errors.Is(err, ErrMalformed) {
+			t.Fatal("expected malformed request for", rawURL)
+		}
+	}
+}
+
+func TestLogFilters(t *testing.T) {
+	req, err := Parse(mustParse("/testRepo/-/log/main?since=2024-01-01&until=2024-03-31&author=Alice"))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if exp := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC); !req.Since.Equal(exp) {
+		t.Fatal("Since: exp=", exp, ", act=", req.Since)
+	}
+	if exp := time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC); !req.Until.Equal(exp) {
+		t.Fatal("Until: exp=", exp, ", act=", req.Until)
+	}
+	if req.Author != "Alice" {
+		t.Fatal("Author: exp=Alice, act=", req.Author)
+	}
+	req, err = Parse(mustParse("/testRepo/-/log/main?since=&until=&author="))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if !req.Since.IsZero() || !req.Until.IsZero() || req.Author != "" {
+		t.Fatal("expected no filters, but got", req.Since, req.Until, req.Author)
+	}
+	for _, rawURL := range []string{
+		"/testRepo/-/log/main?since=yesterday",
+		"/testRepo/-/log/main?until=2024-13-01",
+		"/testRepo/-/commit/main?author=Alice",
+	} {
+		_, err := Parse(mustParse(rawURL))
+		if !errors.Is(err, ErrMalformed) {
+			t.Fatal("expected malformed request for", rawURL)
+		}
+	}
+}